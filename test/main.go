@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	// "encoding/json"
 	"fmt"
 	"strings"
@@ -12,8 +13,12 @@ func main() {
 	lexer := smanchai.NewLexer(strings.NewReader("@user.role.name + \"I\" == \"HII\""))
 	parser := smanchai.NewParser(lexer)
 	ast := parser.Parse()
-	vm := smanchai.Compile(ast)
-	vm.AddStatic("user", func() *smanchai.Data {
+	program, err := smanchai.Compile(ast)
+	if err != nil {
+		panic(err)
+	}
+	env := smanchai.NewEnv()
+	env.Set("user", smanchai.StaticFunc(func() *smanchai.Data {
 		data, err := smanchai.Reflect(
 			struct {
 				role struct {
@@ -29,8 +34,8 @@ func main() {
 			panic(err)
 		}
 		return data
-	})
-	result, err := vm.Run()
+	}))
+	result, err := program.Run(context.Background(), env)
 	if err != nil {
 		panic(err)
 	} else {
@@ -45,7 +50,7 @@ func main() {
 // 	// lexer := smanchai.NewLexer(strings.NewReader("@user.role.name == \"HII\""))
 // 	// lexer := smanchai.NewLexer(strings.NewReader("@user.role.name + \"I\" == \"HII\" and 1.5- 1 == 2"))
 // 	lexer := smanchai.NewLexer(strings.NewReader("2.5 - 10 ** 2"))
-// 	// lexer.Dg = true
+// 	// lexer.Trace = smanchai.NewTextTracer(os.Stdout).OnToken
 // 	parser := smanchai.NewParser(lexer)
 // 	ast := parser.Parse()
 // 	// {
@@ -0,0 +1,418 @@
+package smanchai
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Assemble builds a Program from its text form. It reads the output of
+// Disassemble as well as hand-written code:
+//
+//	.const
+//	#0 int 8
+//	.code
+//	iload #0            ; operands may refer to the pool
+//	iload 10            ; or give the constant inline
+//	imul
+//	getstatic "user"    ; dataref static "user"
+//	getattr "name"      ; attrref read from the previous constant
+//	invoke "HasRole" 1  ; methodref with its argument count
+//	conv string
+//
+// Lines are "#<index> <type> <value>" constants or instructions, optionally
+// preceded by a pc and a line:column position as Disassemble prints them.
+// Mnemonics may keep their Op_ prefix. ".const" and ".code" only help
+// readability. Everything after ";" is a comment.
+func Assemble(src string) (*Program, error) {
+	a := &assembler{
+		consts: map[int]*Data{},
+	}
+	for i, line := range strings.Split(src, "\n") {
+		if err := a.line(line); err != nil {
+			return nil, fmt.Errorf("error: line %d: %w", i+1, err)
+		}
+	}
+	return a.program()
+}
+
+type assembler struct {
+	consts map[int]*Data
+	inline []*Data // constants given as operands, placed after the pool
+	insts  []int
+	pos    []Range
+}
+
+var mnemonics = func() map[string]int {
+	m := map[string]int{}
+	for op, name := range opcodes {
+		if name != "" {
+			m[strings.TrimPrefix(name, "Op_")] = op
+		}
+	}
+	return m
+}()
+
+func (a *assembler) line(line string) error {
+	fields, err := asmFields(stripComment(line))
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	switch {
+	case fields[0] == ".const" || fields[0] == ".code":
+		if len(fields) != 1 {
+			return fmt.Errorf("unexpected %s after %s", fields[1], fields[0])
+		}
+		return nil
+	case strings.HasPrefix(fields[0], "#"):
+		return a.constant(fields)
+	}
+	if _, err := strconv.Atoi(fields[0]); err == nil {
+		fields = fields[1:]
+	}
+	pos := Range{}
+	if len(fields) > 0 && (fields[0] == "-" || strings.Contains(fields[0], ":")) {
+		if fields[0] != "-" {
+			l, c, _ := strings.Cut(fields[0], ":")
+			pos.Line, err = strconv.Atoi(l)
+			if err == nil {
+				pos.Column, err = strconv.Atoi(c)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid position \"%s\"", fields[0])
+			}
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("missing instruction")
+	}
+	op, o := mnemonics[strings.TrimPrefix(fields[0], "Op_")]
+	if !o {
+		return fmt.Errorf("unknown instruction \"%s\"", fields[0])
+	}
+	args := fields[1:]
+	code := []int{op}
+	switch {
+	case operands[op] == 0:
+		if len(args) != 0 {
+			return fmt.Errorf("%s takes no operand", mnemonic(op))
+		}
+	case op == Op_conv:
+		if len(args) != 1 {
+			return fmt.Errorf("conv expects a type")
+		}
+		to, o := conversions[args[0]]
+		if !o {
+			return fmt.Errorf("unknown type \"%s\"", args[0])
+		}
+		code = append(code, int(to))
+	case constOperand(op):
+		i, err := a.constOperand(op, args)
+		if err != nil {
+			return err
+		}
+		code = append(code, i)
+	default:
+		if len(args) != 1 {
+			return fmt.Errorf("%s expects 1 operand", mnemonic(op))
+		}
+		i, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid operand \"%s\"", args[0])
+		}
+		code = append(code, i)
+	}
+	a.insts = append(a.insts, code...)
+	for range code {
+		a.pos = append(a.pos, pos)
+	}
+	return nil
+}
+
+func (a *assembler) constant(fields []string) error {
+	i, err := strconv.Atoi(fields[0][1:])
+	if err != nil || i < 0 {
+		return fmt.Errorf("invalid constant index \"%s\"", fields[0])
+	}
+	if _, o := a.consts[i]; o {
+		return fmt.Errorf("constant #%d defined twice", i)
+	}
+	if len(fields) < 3 && !(len(fields) == 2 && fields[1] == "undefined") {
+		return fmt.Errorf("constant #%d needs a type and a value", i)
+	}
+	d, err := parseConst(fields[1], fields[2:])
+	if err != nil {
+		return err
+	}
+	a.consts[i] = d
+	return nil
+}
+
+// constOperand resolves "#<index>" or an inline constant to its index. Inline
+// indices are offset past the explicit pool once it is complete.
+func (a *assembler) constOperand(op int, args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("%s expects an operand", mnemonic(op))
+	}
+	if len(args) == 1 && strings.HasPrefix(args[0], "#") {
+		i, err := strconv.Atoi(args[0][1:])
+		if err != nil || i < 0 {
+			return 0, fmt.Errorf("invalid constant index \"%s\"", args[0])
+		}
+		return i, nil
+	}
+	var typ string
+	switch op {
+	case Op_getstatic:
+		typ = "dataref"
+		args = append([]string{"static"}, args...)
+	case Op_getattr:
+		typ = "attrref"
+		if len(args) == 1 {
+			// the receiver is the constant read by the previous instruction
+			root, o := a.lastConst()
+			if !o {
+				return 0, fmt.Errorf("getattr \"%s\" does not follow a constant", args[0])
+			}
+			args = append(args, "#"+strconv.Itoa(root))
+		}
+	case Op_invoke:
+		typ = "methodref"
+	case Op_iload:
+		typ = "int"
+		if len(args) == 1 && (args[0] == "true" || args[0] == "false") {
+			typ = "bool"
+		} else if len(args) == 1 && strings.HasPrefix(args[0], "'") {
+			typ = "char"
+		}
+	case Op_dload:
+		typ = "float"
+	case Op_sload:
+		typ = "string"
+	case Op_mload:
+		typ = "decimal"
+	}
+	d, err := parseConst(typ, args)
+	if err != nil {
+		return 0, err
+	}
+	a.inline = append(a.inline, d)
+	return -len(a.inline), nil
+}
+
+// lastConst returns the operand of the previous instruction if it names a
+// constant.
+func (a *assembler) lastConst() (int, bool) {
+	last, o := 0, false
+	for pc := 0; pc < len(a.insts); pc += 1 + operands[a.insts[pc]] {
+		if o = constOperand(a.insts[pc]); o {
+			last = a.insts[pc+1]
+		}
+	}
+	return last, o
+}
+
+func (a *assembler) program() (*Program, error) {
+	p := &Program{
+		options: Options{
+			DecimalScale:    DefaultDecimalScale,
+			DecimalRounding: RoundHalfEven,
+		},
+		consts: make([]*Data, len(a.consts), len(a.consts)+len(a.inline)),
+		insts:  a.insts,
+	}
+	for i, c := range a.consts {
+		if i >= len(a.consts) {
+			return nil, fmt.Errorf("error: constants must be numbered #0 to #%d, found #%d", len(a.consts)-1, i)
+		}
+		p.consts[i] = c
+	}
+	p.consts = append(p.consts, a.inline...)
+	// inline constants were numbered -1, -2, ... while the pool was unknown
+	inline := func(i int) int {
+		if i < 0 {
+			return len(a.consts) - i - 1
+		}
+		return i
+	}
+	for pc := 0; pc < len(p.insts); pc += 1 + operands[p.insts[pc]] {
+		if constOperand(p.insts[pc]) {
+			p.insts[pc+1] = inline(p.insts[pc+1])
+		}
+	}
+	for _, c := range p.consts {
+		if c != nil && c.Type == DTypeAttrRef {
+			c.Value.(*AttrRef).Root = inline(c.Value.(*AttrRef).Root)
+		}
+	}
+	for pc := 0; pc < len(p.insts); pc += 1 + operands[p.insts[pc]] {
+		r := a.pos[pc]
+		if r.Line == 0 || (len(p.ranges) > 0 && p.ranges[len(p.ranges)-1].Range == r) {
+			continue
+		}
+		p.ranges = append(p.ranges, pcRange{PC: pc, Range: r})
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseConst reads a constant written by constText.
+func parseConst(typ string, args []string) (*Data, error) {
+	if typ == "undefined" {
+		return nil, nil
+	}
+	value := strings.Join(args, " ")
+	fail := func() (*Data, error) {
+		return nil, fmt.Errorf("invalid %s constant \"%s\"", typ, value)
+	}
+	if len(args) == 0 {
+		return fail()
+	}
+	var err error
+	d := &Data{}
+	switch typ {
+	case "int", "inst":
+		d.Type = DTypeInt
+		if typ == "inst" {
+			d.Type = DTypeInst
+		}
+		d.Value, err = strconv.Atoi(value)
+	case "float":
+		d.Type = DTypeDouble
+		d.Value, err = strconv.ParseFloat(value, 64)
+	case "bool":
+		d.Type = DTypeBool
+		d.Value, err = strconv.ParseBool(value)
+	case "char":
+		d.Type = DTypeChar
+		var s string
+		if s, err = strconv.Unquote(value); err == nil && len([]rune(s)) == 1 {
+			d.Value = []rune(s)[0]
+		} else {
+			return fail()
+		}
+	case "string":
+		d.Type = DTypeString
+		d.Value, err = strconv.Unquote(value)
+	case "decimal":
+		d.Type = DTypeDecimal
+		r, o := new(big.Rat).SetString(value)
+		if !o {
+			return fail()
+		}
+		d.Value = Decimal{rat: r}
+	case "dataref":
+		if len(args) != 2 {
+			return fail()
+		}
+		root := -1
+		for i, name := range dataRefRoots {
+			if name == args[0] {
+				root = i
+			}
+		}
+		name, err := strconv.Unquote(args[1])
+		if root < 0 || err != nil {
+			return fail()
+		}
+		d.Type = DTypeDataRef
+		d.Value = &DataRef{Name: name, Root: DataRefType(root)}
+	case "attrref":
+		if len(args) != 2 || !strings.HasPrefix(args[1], "#") {
+			return fail()
+		}
+		name, err := strconv.Unquote(args[0])
+		if err != nil {
+			return fail()
+		}
+		root, err := strconv.Atoi(args[1][1:])
+		if err != nil {
+			return fail()
+		}
+		d.Type = DTypeAttrRef
+		d.Value = &AttrRef{Name: name, Root: root}
+	case "methodref":
+		if len(args) != 2 {
+			return fail()
+		}
+		name, err := strconv.Unquote(args[0])
+		if err != nil {
+			return fail()
+		}
+		argc, err := strconv.Atoi(args[1])
+		if err != nil || argc < 0 {
+			return fail()
+		}
+		d.Type = DTypeMethodRef
+		d.Value = &MethodRef{Name: name, Argc: argc}
+	case "array", "object":
+		return readTypedJSON([]byte(value))
+	default:
+		return nil, fmt.Errorf("unknown constant type \"%s\"", typ)
+	}
+	if err != nil {
+		return fail()
+	}
+	return d, nil
+}
+
+// stripComment removes everything after a ";" that is not inside quotes.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// asmFields splits line at spaces, keeping quoted strings whole wherever they
+// appear, so the strings inside an array or object constant keep their spaces.
+func asmFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	for line != "" {
+		c, size := utf8.DecodeRuneInString(line)
+		switch {
+		case c == '"' || c == '\'':
+			q, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line)
+			}
+			field.WriteString(q)
+			line = line[len(q):]
+			continue
+		case unicode.IsSpace(c):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(c)
+		}
+		line = line[size:]
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,37 @@
+package smanchai
+
+import "testing"
+
+func TestAssembleQuotedSpaces(t *testing.T) {
+	obj := NewObject()
+	obj.Set("two  spaces", NewString("x   y"))
+	obj.Set("k", NewArray(NewString("p  q")))
+	tests := []*Data{
+		NewString("a  b"),
+		NewString("tab\there ; not a comment"),
+		NewArray(NewString("a  b"), NewString(" "), NewInt(1)),
+		obj,
+	}
+	for _, d := range tests {
+		src := "#0 " + typeName(d) + " " + constText(d, true) + "\nsload \"x\""
+		p, err := Assemble(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if !p.consts[0].Equal(d) {
+			t.Errorf("%s: read back as %s", src, p.consts[0])
+		}
+	}
+	if _, err := Assemble(`sload "open`); err == nil {
+		t.Error("assembled an unterminated string")
+	}
+}
+
+func TestAssembleRejectsLabels(t *testing.T) {
+	for _, src := range []string{"start:\niload 1", "iload 1\niinc start"} {
+		if _, err := Assemble(src); err == nil {
+			t.Errorf("%q: assembled a label", src)
+		}
+	}
+}
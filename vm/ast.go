@@ -12,6 +12,8 @@ const (
 	AstExpression
 	AstConjunction
 	AstDisjunction
+	AstFunction
+	AstMethod
 )
 
 var ast = []string{
@@ -24,6 +26,8 @@ var ast = []string{
 	AstExpression:  "AstExpression",
 	AstConjunction: "AstConjunction",
 	AstDisjunction: "AstDisjunction",
+	AstFunction:    "AstFunction",
+	AstMethod:      "AstMethod",
 }
 
 func (s AstType) String() string {
@@ -36,6 +40,7 @@ const (
 	LSTRING = iota
 	LNUMBER
 	LBOOLEAN
+	LDECIMAL
 )
 
 type EquationOpr int
@@ -67,6 +72,7 @@ type Node struct {
 
 type ProgramNode struct {
 	Children []*Node
+	Source   string // text the program was parsed from, for error messages
 }
 
 type IdentifierNode struct {
@@ -85,6 +91,12 @@ type FunctionNode struct {
 	Params []*Node
 }
 
+type MethodNode struct {
+	Receiver *Node
+	Name     string
+	Params   []*Node
+}
+
 type ComparisonNode struct {
 	Left  *Node
 	Op    ComparisonOpr
@@ -0,0 +1,393 @@
+package smanchai
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"slices"
+	"unicode/utf8"
+)
+
+// Binary format of a Program, as written by MarshalBinary:
+//
+//	magic     "SMCB"
+//	version   uint16, big endian (binaryVersion)
+//	options   uvarint DecimalScale, uvarint DecimalRounding, byte flags
+//	          (1 StrictBool, 2 StrictCompare, 4 NoOptimize)
+//	insts     uvarint count, then one varint per word
+//	consts    uvarint count, then one constant each
+//	ranges    uvarint count, then uvarint pc, line, column, index, end each
+//	source    string
+//	checksum  uint32 CRC-32 (IEEE) of everything before it, big endian
+//
+// A constant is its dataType as one byte followed by its value: varint for
+// int, char and inst, 8 bytes IEEE 754 for float, one byte for bool, a string
+// for string and decimal (as an exact fraction), name string and varint for
+// DataRef and AttrRef and MethodRef, uvarint count and items for array, and
+// uvarint count and key string, item pairs for object. A string is its uvarint
+// length and bytes. nilConst stands for an undefined item.
+//
+// Options.Methods and Options.Collation are functions and Options.Limits is
+// left to whoever runs the program; none of them are stored.
+const (
+	binaryMagic = "SMCB"
+	// binaryVersion must change whenever opcodes, data types or the layout
+	// above change, so stale caches are rejected rather than misread.
+	binaryVersion = 2
+	nilConst      = 0xff
+)
+
+const (
+	flagStrictBool = 1 << iota
+	flagStrictCompare
+	flagNoOptimize
+)
+
+func (p *Program) MarshalBinary() ([]byte, error) {
+	b := []byte(binaryMagic)
+	b = binary.BigEndian.AppendUint16(b, binaryVersion)
+	b = binary.AppendUvarint(b, uint64(p.options.DecimalScale))
+	b = binary.AppendUvarint(b, uint64(p.options.DecimalRounding))
+	var flags byte
+	if p.options.StrictBool {
+		flags |= flagStrictBool
+	}
+	if p.options.StrictCompare {
+		flags |= flagStrictCompare
+	}
+	if p.options.NoOptimize {
+		flags |= flagNoOptimize
+	}
+	b = append(b, flags)
+	b = binary.AppendUvarint(b, uint64(len(p.insts)))
+	for _, inst := range p.insts {
+		b = binary.AppendVarint(b, int64(inst))
+	}
+	b = binary.AppendUvarint(b, uint64(len(p.consts)))
+	for _, c := range p.consts {
+		var err error
+		if b, err = appendConst(b, c); err != nil {
+			return nil, err
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(p.ranges)))
+	for _, r := range p.ranges {
+		b = binary.AppendUvarint(b, uint64(r.PC))
+		b = binary.AppendUvarint(b, uint64(r.Range.Line))
+		b = binary.AppendUvarint(b, uint64(r.Range.Column))
+		b = binary.AppendUvarint(b, uint64(r.Range.Index))
+		b = binary.AppendUvarint(b, uint64(r.Range.End))
+	}
+	b = appendString(b, p.source)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b)), nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendConst(b []byte, d *Data) ([]byte, error) {
+	if d == nil {
+		return append(b, nilConst), nil
+	}
+	b = append(b, byte(d.Type))
+	switch d.Type {
+	case DTypeInt, DTypeChar, DTypeInst:
+		i, _ := intValue(d.Value)
+		b = binary.AppendVarint(b, i)
+	case DTypeDouble:
+		b = binary.BigEndian.AppendUint64(b, math.Float64bits(toFloat64(d.Value)))
+	case DTypeBool:
+		if boolValue(d.Value) {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	case DTypeString:
+		b = appendString(b, d.Value.(string))
+	case DTypeDecimal:
+		b = appendString(b, d.Value.(Decimal).get().RatString())
+	case DTypeDataRef:
+		ref := d.Value.(*DataRef)
+		b = appendString(b, ref.Name)
+		b = binary.AppendVarint(b, int64(ref.Root))
+	case DTypeAttrRef:
+		ref := d.Value.(*AttrRef)
+		b = appendString(b, ref.Name)
+		b = binary.AppendVarint(b, int64(ref.Root))
+	case DTypeMethodRef:
+		ref := d.Value.(*MethodRef)
+		b = appendString(b, ref.Name)
+		b = binary.AppendVarint(b, int64(ref.Argc))
+	case DTypeArray:
+		arr := d.Value.(*DataObjectArray).Data
+		b = binary.AppendUvarint(b, uint64(len(arr)))
+		for _, v := range arr {
+			var err error
+			if b, err = appendConst(b, v); err != nil {
+				return nil, err
+			}
+		}
+	case DTypeObject:
+		omap := d.Value.(*DataObjectMap)
+		keys := omap.OrderedKeys()
+		b = binary.AppendUvarint(b, uint64(len(keys)))
+		for _, k := range keys {
+			b = appendString(b, k)
+			var err error
+			if b, err = appendConst(b, omap.Data[k]); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("error: cannot encode constant of type %s", d.Type)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary replaces p with a Program written by MarshalBinary. Methods,
+// Collation and Limits already set on p are kept.
+func (p *Program) UnmarshalBinary(b []byte) error {
+	if len(b) < len(binaryMagic)+2+4 || string(b[:len(binaryMagic)]) != binaryMagic {
+		return fmt.Errorf("error: not a compiled program")
+	}
+	if v := binary.BigEndian.Uint16(b[len(binaryMagic):]); v != binaryVersion {
+		return fmt.Errorf("error: compiled program has version %d, expected %d", v, binaryVersion)
+	}
+	body, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return fmt.Errorf("error: compiled program checksum mismatch")
+	}
+	r := &binaryReader{b: body[len(binaryMagic)+2:]}
+	q := &Program{options: p.options}
+	q.options.DecimalScale = int(r.uvarint())
+	q.options.DecimalRounding = RoundingMode(r.uvarint())
+	flags := r.bytes(1)[0]
+	q.options.StrictBool = flags&flagStrictBool != 0
+	q.options.StrictCompare = flags&flagStrictCompare != 0
+	q.options.NoOptimize = flags&flagNoOptimize != 0
+	q.insts = make([]int, r.count())
+	for i := range q.insts {
+		q.insts[i] = int(r.varint())
+	}
+	q.consts = make([]*Data, r.count())
+	for i := range q.consts {
+		q.consts[i] = r.constant()
+	}
+	q.ranges = make([]pcRange, r.count())
+	for i := range q.ranges {
+		q.ranges[i].PC = int(r.uvarint())
+		q.ranges[i].Range.Line = int(r.uvarint())
+		q.ranges[i].Range.Column = int(r.uvarint())
+		q.ranges[i].Range.Index = int(r.uvarint())
+		q.ranges[i].Range.End = int(r.uvarint())
+	}
+	q.source = r.string()
+	if r.err == nil && len(r.b) != 0 {
+		r.err = fmt.Errorf("error: unexpected data after compiled program")
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if err := q.validate(); err != nil {
+		return err
+	}
+	*p = *q
+	return nil
+}
+
+// constTypes lists the constant types each opcode with a pool operand loads.
+var constTypes = map[int][]dataType{
+	Op_getstatic: {DTypeDataRef},
+	Op_getattr:   {DTypeAttrRef},
+	Op_invoke:    {DTypeMethodRef},
+	Op_iload:     {DTypeInt, DTypeBool, DTypeChar},
+	Op_dload:     {DTypeDouble},
+	Op_sload:     {DTypeString},
+	Op_mload:     {DTypeDecimal},
+}
+
+// validate checks that every opcode is known, every constant operand is in the
+// pool and of the type its opcode loads, every conv names a conversion and
+// every reference and source range points inside the program. Stack depth is
+// not checked: a malformed program can still underflow the stack, which Run
+// reports as a StackUnderflow error.
+func (p *Program) validate() error {
+	if p.options.DecimalRounding < RoundHalfEven || p.options.DecimalRounding > RoundFloor {
+		return fmt.Errorf("error: unknown rounding mode %d", p.options.DecimalRounding)
+	}
+	for pc := 0; pc < len(p.insts); pc += 1 + operands[p.insts[pc]] {
+		op := p.insts[pc]
+		if op <= Op || op >= len(opcodes) || opcodes[op] == "" {
+			return fmt.Errorf("error: unknown opcode %d at %d", op, pc)
+		}
+		if pc+operands[op] >= len(p.insts) {
+			return fmt.Errorf("error: missing operand of %s at %d", Opcode(op), pc)
+		}
+		if op == Op_conv && !conversion(dataType(p.insts[pc+1])) {
+			return fmt.Errorf("error: %s to unknown type %d at %d", Opcode(op), p.insts[pc+1], pc)
+		}
+		if !constOperand(op) {
+			continue
+		}
+		i := p.insts[pc+1]
+		if i < 0 || i >= len(p.consts) {
+			return fmt.Errorf("error: constant %d of %s at %d out of range", i, Opcode(op), pc)
+		}
+		if c := p.consts[i]; c == nil || !slices.Contains(constTypes[op], c.Type) {
+			return fmt.Errorf("error: %s at %d cannot load %s constant", Opcode(op), pc, typeName(c))
+		}
+	}
+	for i, c := range p.consts {
+		if c == nil {
+			continue
+		}
+		switch c.Type {
+		case DTypeDataRef:
+			if root := c.Value.(*DataRef).Root; root < 0 || int(root) >= len(dataRefRoots) {
+				return fmt.Errorf("error: constant %d has unknown root %d", i, root)
+			}
+		case DTypeAttrRef:
+			if root := c.Value.(*AttrRef).Root; root < 0 || root >= len(p.consts) {
+				return fmt.Errorf("error: constant %d reads from constant %d out of range", i, root)
+			}
+		case DTypeMethodRef:
+			if argc := c.Value.(*MethodRef).Argc; argc < 0 {
+				return fmt.Errorf("error: constant %d calls a method with %d arguments", i, argc)
+			}
+		}
+	}
+	n := utf8.RuneCountInString(p.source)
+	for _, r := range p.ranges {
+		if r.PC < 0 || r.Range.Line < 0 || r.Range.Column < 0 || r.Range.Index < 0 || r.Range.End < r.Range.Index || r.Range.End > n {
+			return fmt.Errorf("error: source range %s of %d out of range", r.Range.String(), r.PC)
+		}
+	}
+	return nil
+}
+
+// conversion reports whether t is the target of a conversion built-in.
+func conversion(t dataType) bool {
+	for _, to := range conversions {
+		if to == t {
+			return true
+		}
+	}
+	return false
+}
+
+type binaryReader struct {
+	b   []byte
+	err error
+}
+
+func (r *binaryReader) fail() {
+	if r.err == nil {
+		r.err = fmt.Errorf("error: truncated compiled program")
+	}
+	r.b = nil
+}
+
+func (r *binaryReader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.fail()
+		return make([]byte, n)
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// count reads a length, which can never exceed the bytes left since every
+// item takes at least one.
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.b)) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes(r.count()))
+}
+
+func (r *binaryReader) constant() *Data {
+	t := r.bytes(1)[0]
+	if t == nilConst || r.err != nil {
+		return nil
+	}
+	d := &Data{Type: dataType(t)}
+	switch d.Type {
+	case DTypeInt, DTypeChar, DTypeInst:
+		d.Value = int(r.varint())
+		if d.Type == DTypeChar {
+			d.Value = rune(d.Value.(int))
+		}
+	case DTypeDouble:
+		d.Value = math.Float64frombits(binary.BigEndian.Uint64(r.bytes(8)))
+	case DTypeBool:
+		d.Value = r.bytes(1)[0] != 0
+	case DTypeString:
+		d.Value = r.string()
+	case DTypeDecimal:
+		s := r.string()
+		m, o := new(big.Rat).SetString(s)
+		if !o && r.err == nil {
+			r.err = fmt.Errorf("error: invalid decimal constant \"%s\"", s)
+		}
+		d.Value = Decimal{rat: m}
+	case DTypeDataRef:
+		d.Value = &DataRef{Name: r.string(), Root: DataRefType(r.varint())}
+	case DTypeAttrRef:
+		d.Value = &AttrRef{Name: r.string(), Root: int(r.varint())}
+	case DTypeMethodRef:
+		d.Value = &MethodRef{Name: r.string(), Argc: int(r.varint())}
+	case DTypeArray:
+		arr := make([]*Data, r.count())
+		for i := range arr {
+			arr[i] = r.constant()
+		}
+		d.Value = &DataObjectArray{Data: arr}
+	case DTypeObject:
+		n := r.count()
+		omap := &DataObjectMap{Data: make(map[string]*Data, n), Keys: make([]string, 0, n)}
+		for i := 0; i < n; i++ {
+			k := r.string()
+			if _, o := omap.Data[k]; !o {
+				omap.Keys = append(omap.Keys, k)
+			}
+			omap.Data[k] = r.constant()
+		}
+		d.Value = omap
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("error: unknown constant type %d", t)
+		}
+	}
+	return d
+}
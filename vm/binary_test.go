@@ -0,0 +1,152 @@
+package smanchai
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	tests := []string{
+		`1 + 2 * @n`,
+		`@cart.total / @cart.count > 10`,
+		`"total: " + string(@cart.total)`,
+		`1.5m * @price + decimal(@n)`,
+		`@a and @b or @n == 0`,
+		`int("42") + float(@n) ** 2`,
+	}
+	for _, src := range tests {
+		p := compile(t, src)
+		b, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		q := &Program{}
+		if err := q.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if got, want := Disassemble(q), Disassemble(p); got != want {
+			t.Errorf("%s: decoded as\n%s\nwant\n%s", src, got, want)
+		}
+		if c, _ := q.MarshalBinary(); !bytes.Equal(c, b) {
+			t.Errorf("%s: encoding changed after a round trip", src)
+		}
+	}
+}
+
+func TestBinaryRoundTripRuns(t *testing.T) {
+	p := compile(t, `@price * 2m + 1.25m`)
+	b, _ := p.MarshalBinary()
+	q := &Program{}
+	if err := q.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnv()
+	env.Set("price", StaticValue(NewDecimal(MustParseDecimal("2.5"))))
+	r, err := q.Run(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := r.Decimal(); !m.Equal(MustParseDecimal("6.25")) {
+		t.Errorf("got %s, want 6.25", r)
+	}
+}
+
+// constOf returns the first constant of type typ in p.
+func constOf(p *Program, typ dataType) int {
+	for i, c := range p.consts {
+		if c != nil && c.Type == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestBinaryRejectsCorrupted(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		mutate func(p *Program)
+		want   string
+	}{
+		{"unknown opcode", `@a.b + 1`, func(p *Program) { p.insts[0] = 999 }, "unknown opcode"},
+		{"missing operand", `@a.b + 1`, func(p *Program) { p.insts = p.insts[:1] }, "missing operand"},
+		{"constant out of range", `@a.b + 1`, func(p *Program) { p.insts[1] = len(p.consts) }, "out of range"},
+		{"negative constant", `@a.b + 1`, func(p *Program) { p.insts[1] = -1 }, "out of range"},
+		{"wrong constant type", `@a.b + 1`, func(p *Program) { p.insts[1] = constOf(p, DTypeAttrRef) }, "cannot load attrref"},
+		{"undefined constant", `@a.b + 1`, func(p *Program) { p.consts[p.insts[1]] = nil }, "cannot load undefined"},
+		{"unknown conversion", `string(@a)`, func(p *Program) { p.insts[len(p.insts)-1] = 99 }, "unknown type"},
+		{"unknown static root", `@a.b + 1`, func(p *Program) {
+			p.consts[constOf(p, DTypeDataRef)].Value.(*DataRef).Root = 7
+		}, "unknown root"},
+		{"attribute root out of range", `@a.b + 1`, func(p *Program) {
+			p.consts[constOf(p, DTypeAttrRef)].Value.(*AttrRef).Root = -1
+		}, "out of range"},
+		{"negative argument count", `@u.HasRole("a")`, func(p *Program) {
+			p.consts[constOf(p, DTypeMethodRef)].Value.(*MethodRef).Argc = -1
+		}, "-1 arguments"},
+		{"range past the source", `@a.b + 1`, func(p *Program) { p.ranges[0].Range.End = 100 }, "source range"},
+		{"unknown rounding mode", `1.5m / 3m`, func(p *Program) { p.options.DecimalRounding = 9 }, "rounding mode"},
+	}
+	for _, tt := range tests {
+		p := compile(t, tt.src)
+		tt.mutate(p)
+		b, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		err = (&Program{}).UnmarshalBinary(b)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// resum replaces the checksum of a compiled program body.
+func resum(body []byte) []byte {
+	b := append([]byte(nil), body...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+func TestBinaryRejectsTruncated(t *testing.T) {
+	b, err := compile(t, `"total: " + string(@cart.total * 1.5m)`).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := b[:len(b)-4]
+	for n := 0; n < len(b); n++ {
+		if err := (&Program{}).UnmarshalBinary(b[:n]); err == nil {
+			t.Errorf("decoded %d of %d bytes", n, len(b))
+		}
+		if n < len(body) {
+			if err := (&Program{}).UnmarshalBinary(resum(body[:n])); err == nil {
+				t.Errorf("decoded %d of %d bytes with a valid checksum", n, len(body))
+			}
+		}
+	}
+	flip := append([]byte(nil), b...)
+	flip[len(flip)/2] ^= 1
+	if err := (&Program{}).UnmarshalBinary(flip); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("got error %v, want a checksum mismatch", err)
+	}
+}
+
+func TestBinaryCorruptedDoesNotPanic(t *testing.T) {
+	b, _ := compile(t, `@cart.items.0 + int(@n) * 2 > 3 and @ok`).MarshalBinary()
+	body := b[len(binaryMagic)+2 : len(b)-4]
+	for i := range body {
+		for _, bit := range []byte{1, 0x40, 0x80} {
+			c := append([]byte(nil), b[:len(b)-4]...)
+			c[len(binaryMagic)+2+i] ^= bit
+			p := &Program{}
+			if p.UnmarshalBinary(resum(c)) != nil {
+				continue
+			}
+			// whatever decodes must fail cleanly rather than panic
+			p.Run(context.Background(), NewEnv())
+		}
+	}
+}
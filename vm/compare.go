@@ -0,0 +1,168 @@
+package smanchai
+
+import (
+	"math"
+	"math/big"
+	"strings"
+)
+
+// compare evaluates a1 <op> a0 for the Op_cmp_* opcodes.
+//
+// Int, float and decimal compare by numeric value, so 1 == 1.0 == 1m. Strings
+// order lexicographically (by Options.Collation when set) and a char compares like
+// a one-character string. Arrays and objects are equal when their items and
+// attributes are deeply equal. Bools and undefined only equal themselves.
+//
+// Comparing incompatible types yields false for == and the orderings and true
+// for !=; with StrictCompare it is an error instead. Undefined may always be
+// tested with == and !=. NaN is a number but unordered: it equals nothing, not
+// even itself, and every ordering with it is false, with or without
+// StrictCompare.
+func (vm *VM) compare(inst int, a1 *Data, a0 *Data) (*Data, error) {
+	switch inst {
+	case Op_cmp_eq, Op_cmp_ne:
+		eq, ok := vm.equal(a1, a0)
+		if !ok && vm.options.StrictCompare && a1 != nil && a0 != nil {
+			return nil, incompatible(inst, a1, a0)
+		}
+		return newBool(eq == (inst == Op_cmp_eq)), nil
+	}
+	c, ok := vm.order(a1, a0)
+	if !ok {
+		if vm.options.StrictCompare && !numbers(a1, a0) {
+			return nil, incompatible(inst, a1, a0)
+		}
+		return newBool(false), nil
+	}
+	v := false
+	switch inst {
+	case Op_cmp_g:
+		v = c > 0
+	case Op_cmp_ge:
+		v = c >= 0
+	case Op_cmp_l:
+		v = c < 0
+	case Op_cmp_le:
+		v = c <= 0
+	}
+	return newBool(v), nil
+}
+
+func incompatible(inst int, a1 *Data, a0 *Data) error {
+	return newError(TypeMismatch, "error: cannot compare %s and %s with %s", typeName(a1), typeName(a0), Opcode(inst))
+}
+
+// equal reports whether a and b are equal and whether their types could be
+// compared at all.
+func (vm *VM) equal(a *Data, b *Data) (bool, bool) {
+	if a == nil || b == nil {
+		return a == nil && b == nil, a == nil && b == nil
+	}
+	switch {
+	case a.Type == DTypeArray && b.Type == DTypeArray:
+		x, y := a.Value.(*DataObjectArray).Data, b.Value.(*DataObjectArray).Data
+		if len(x) != len(y) {
+			return false, true
+		}
+		for i := range x {
+			e, _ := vm.equal(x[i], y[i])
+			if !e {
+				return false, true
+			}
+		}
+		return true, true
+	case a.Type == DTypeObject && b.Type == DTypeObject:
+		x, y := a.Value.(*DataObjectMap).Data, b.Value.(*DataObjectMap).Data
+		if len(x) != len(y) {
+			return false, true
+		}
+		for k, v := range x {
+			w, o := y[k]
+			if !o {
+				return false, true
+			}
+			if e, _ := vm.equal(v, w); !e {
+				return false, true
+			}
+		}
+		return true, true
+	case a.Type == DTypeBool && b.Type == DTypeBool:
+		return boolValue(a.Value) == boolValue(b.Value), true
+	}
+	c, ok := vm.order(a, b)
+	return ok && c == 0, ok || numbers(a, b)
+}
+
+// numbers reports whether a and b are both numbers, which are always
+// comparable even when NaN leaves them unordered.
+func numbers(a *Data, b *Data) bool {
+	return a != nil && b != nil && isNumeric(a) && isNumeric(b)
+}
+
+// order returns the sign of a - b for numbers, strings and chars, and false
+// when a and b have no ordering.
+func (vm *VM) order(a *Data, b *Data) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if isNumeric(a) && isNumeric(b) {
+		return compareNumbers(a, b)
+	}
+	if isText(a) && isText(b) {
+		x, y := a.String(), b.String()
+		if vm.options.Collation != nil {
+			return vm.options.Collation(x, y), true
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+func isNumeric(d *Data) bool {
+	return d.Type == DTypeInt || d.Type == DTypeDouble || d.Type == DTypeDecimal
+}
+
+func isText(d *Data) bool {
+	return d.Type == DTypeString || d.Type == DTypeChar
+}
+
+// compareNumbers compares exactly: ints against floats through big.Float, and
+// anything against a decimal in decimal, using the shortest representation of
+// floats so 0.1 == 0.1m. NaN is unordered.
+func compareNumbers(a *Data, b *Data) (int, bool) {
+	if a.Type == DTypeInt && b.Type == DTypeInt {
+		x, _ := intValue(a.Value)
+		y, _ := intValue(b.Value)
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	if (a.Type == DTypeDouble && math.IsNaN(toFloat64(a.Value))) || (b.Type == DTypeDouble && math.IsNaN(toFloat64(b.Value))) {
+		return 0, false
+	}
+	if a.Type == DTypeDecimal || b.Type == DTypeDecimal {
+		x, errx := toDecimal(a)
+		y, erry := toDecimal(b)
+		if errx == nil && erry == nil {
+			return x.Cmp(y), true
+		}
+		// only ±Inf fails to convert; it orders beyond every decimal
+	}
+	return bigFloat(a).Cmp(bigFloat(b)), true
+}
+
+func bigFloat(d *Data) *big.Float {
+	switch d.Type {
+	case DTypeInt:
+		i, _ := intValue(d.Value)
+		return new(big.Float).SetInt64(i)
+	case DTypeDecimal:
+		f, _ := d.Value.(Decimal).Float64()
+		return big.NewFloat(f)
+	}
+	return big.NewFloat(toFloat64(d.Value))
+}
@@ -0,0 +1,87 @@
+package smanchai
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	m := func(s string) *Data { return NewDecimal(MustParseDecimal(s)) }
+	nan := NewFloat(math.NaN())
+	tests := []struct {
+		a1, a0 *Data
+		inst   int
+		want   bool
+	}{
+		// cross-type numbers
+		{NewInt(1), NewFloat(1), Op_cmp_eq, true},
+		{NewInt(1), NewFloat(1.5), Op_cmp_l, true},
+		{NewInt(1<<53 + 1), NewFloat(1 << 53), Op_cmp_g, true},
+		{NewInt(1<<53 + 1), NewFloat(1 << 53), Op_cmp_ne, true},
+		{NewInt(2), m("2.0"), Op_cmp_eq, true},
+		{m("2.5"), NewInt(2), Op_cmp_ge, true},
+		{NewInt(-3), m("-2.9"), Op_cmp_le, true},
+		// decimal and double
+		{m("0.1"), NewFloat(0.1), Op_cmp_eq, true},
+		{m("0.3"), NewFloat(0.30000000000000004), Op_cmp_eq, false},
+		{m("0.3"), NewFloat(0.30000000000000004), Op_cmp_l, true},
+		{NewFloat(math.Inf(1)), m("1e30"), Op_cmp_g, true},
+		{NewFloat(math.Inf(-1)), m("-1e30"), Op_cmp_l, true},
+		// strings and chars
+		{NewString("abc"), NewString("abc"), Op_cmp_eq, true},
+		{NewString("abc"), NewString("abd"), Op_cmp_l, true},
+		{NewString("b"), NewString("abc"), Op_cmp_g, true},
+		{NewString(""), NewString("a"), Op_cmp_l, true},
+		{NewChar('a'), NewString("a"), Op_cmp_eq, true},
+		{NewChar('b'), NewString("a"), Op_cmp_g, true},
+		{NewChar('a'), NewChar('b'), Op_cmp_ne, true},
+		// others
+		{NewBool(true), NewBool(true), Op_cmp_eq, true},
+		{nil, nil, Op_cmp_eq, true},
+		{nil, NewInt(0), Op_cmp_eq, false},
+		{NewArray(NewInt(1), m("2")), NewArray(NewFloat(1), NewInt(2)), Op_cmp_eq, true},
+		// NaN is unordered
+		{nan, nan, Op_cmp_eq, false},
+		{nan, nan, Op_cmp_ne, true},
+		{nan, NewInt(1), Op_cmp_l, false},
+		{nan, NewInt(1), Op_cmp_ge, false},
+		{m("1"), nan, Op_cmp_le, false},
+	}
+	for _, strict := range []bool{false, true} {
+		vm := &VM{Program: &Program{options: Options{StrictCompare: strict}}}
+		for _, tt := range tests {
+			r, err := vm.compare(tt.inst, tt.a1, tt.a0)
+			if err != nil || r.Value != tt.want {
+				t.Errorf("strict %v: %s %s %s = %s, %v, want %v", strict, tt.a1, Opcode(tt.inst), tt.a0, r, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestCompareIncompatible(t *testing.T) {
+	tests := []struct {
+		a1, a0 *Data
+		inst   int
+		want   bool
+	}{
+		{NewString("1"), NewInt(1), Op_cmp_eq, false},
+		{NewString("1"), NewInt(1), Op_cmp_ne, true},
+		{NewString("1"), NewInt(1), Op_cmp_l, false},
+		{NewBool(true), NewInt(1), Op_cmp_eq, false},
+		{NewBool(true), NewBool(false), Op_cmp_g, false},
+		{nil, NewInt(1), Op_cmp_l, false},
+		{NewArray(), NewObject(), Op_cmp_eq, false},
+	}
+	lax := &VM{Program: &Program{}}
+	strict := &VM{Program: &Program{options: Options{StrictCompare: true}}}
+	for _, tt := range tests {
+		r, err := lax.compare(tt.inst, tt.a1, tt.a0)
+		if err != nil || r.Value != tt.want {
+			t.Errorf("%s %s %s = %s, %v, want %v", tt.a1, Opcode(tt.inst), tt.a0, r, err, tt.want)
+		}
+		if r, err := strict.compare(tt.inst, tt.a1, tt.a0); !errors.Is(err, TypeMismatch) {
+			t.Errorf("strict: %s %s %s = %s, %v, want a type mismatch", tt.a1, Opcode(tt.inst), tt.a0, r, err)
+		}
+	}
+}
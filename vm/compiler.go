@@ -1,38 +1,63 @@
 package smanchai
 
 import (
+	"fmt"
+	"runtime"
 	"strconv"
 )
 
+// Visitor generates code for an AST straight into its instruction and constant
+// buffers.
 type Visitor struct {
-	emitter chan *emitted
-	constc  int
-	state   int
+	opts       Options
+	consts     []*Data
+	insts      []int
+	source     string
+	pos        []Range        // source position of every word in insts
+	cur        Range          // position of the innermost node being visited
+	constIndex map[string]int // constKey -> index in consts
 }
 
 func NewVisitor() *Visitor {
 	return &Visitor{
-		emitter: make(chan *emitted),
-		constc:  0,
+		consts:     make([]*Data, 0, 64),
+		insts:      make([]int, 0, 256),
+		constIndex: map[string]int{},
 	}
 }
 
-func (v *Visitor) Emitter() chan *emitted {
-	return v.emitter
+func (v *Visitor) emit(code ...int) {
+	v.insts = append(v.insts, code...)
+	for range code {
+		v.pos = append(v.pos, v.cur)
+	}
 }
 
-func consInst(opcode int) *emitted {
-	return &emitted{
-		Type:  E_Inst,
-		Value: opcode,
+// emitConst adds c to the constant pool, reusing an identical constant unless
+// optimization is off, and emits opcode with its index.
+func (v *Visitor) emitConst(opcode int, c *Data) int {
+	k, o := constKey(c)
+	if o && !v.opts.NoOptimize {
+		if i, o := v.constIndex[k]; o {
+			v.emit(opcode, i)
+			return i
+		}
+		v.constIndex[k] = len(v.consts)
 	}
+	v.consts = append(v.consts, c)
+	v.emit(opcode, len(v.consts)-1)
+	return len(v.consts) - 1
 }
 
 func (v *Visitor) Accept(node *Node) {
 	if node == nil {
-		v.emitter <- nil
 		return
 	}
+	mark, cmark := len(v.insts), len(v.consts)
+	prev := v.cur
+	if node.Range.Line != 0 {
+		v.cur = node.Range
+	}
 	switch node.Type {
 	case AstProgram:
 		v.visitProgram(node.Object.(*ProgramNode))
@@ -52,58 +77,196 @@ func (v *Visitor) Accept(node *Node) {
 		v.visitIdentifier(node.Object.(*IdentifierNode))
 	case AstLiteral:
 		v.visitLiteral(node.Object.(*LiteralNode))
+	case AstFunction:
+		v.visitFunction(node.Object.(*FunctionNode))
+	case AstMethod:
+		v.visitMethod(node.Object.(*MethodNode))
 	default:
-		panic("I forgot to implement Visitor.")
+		panic(fmt.Errorf("error: cannot compile node type %d", node.Type))
+	}
+	if !v.opts.NoOptimize {
+		v.fold(mark, cmark)
 	}
+	v.cur = prev
 }
 
 func (v *Visitor) visitProgram(node *ProgramNode) {
+	v.source = node.Source
 	for i := 0; i < len(node.Children); i++ {
 		v.Accept(node.Children[i])
 	}
-	v.emitter <- nil
+}
+
+// inferType returns the dataType node is known to produce at compile time, or
+// -1 when it depends on runtime values such as statics.
+func inferType(node *Node) dataType {
+	if node == nil {
+		return -1
+	}
+	switch node.Type {
+	case AstPrimitive:
+		return inferType(node.Object.(*Node))
+	case AstLiteral:
+		switch node.Object.(*LiteralNode).Type {
+		case LSTRING:
+			return DTypeString
+		case LNUMBER:
+			return DTypeDouble
+		case LBOOLEAN:
+			return DTypeBool
+		case LDECIMAL:
+			return DTypeDecimal
+		}
+	case AstExpression:
+		expr := node.Object.(*ExpressionNode)
+		l, r := inferType(expr.Left), inferType(expr.Right)
+		switch {
+		case expr.Op == EOpADD && (l == DTypeString || r == DTypeString):
+			return DTypeString
+		case l == DTypeDecimal || r == DTypeDecimal:
+			return DTypeDecimal
+		case l == DTypeInt && r == DTypeInt && (expr.Op == EOpADD || expr.Op == EOprSUB || expr.Op == EOprMULT):
+			return DTypeInt
+		case (l == DTypeInt || l == DTypeDouble) && (r == DTypeInt || r == DTypeDouble):
+			return DTypeDouble
+		}
+	case AstEquality, AstComparison, AstConjunction, AstDisjunction:
+		return DTypeBool
+	case AstFunction:
+		if t, o := conversions[node.Object.(*FunctionNode).Name]; o {
+			return t
+		}
+	}
+	return -1
+}
+
+// promote emits the implicit conversion of a value of type from, already on
+// the stack, to type to. Like the conversion built-ins it fails at runtime
+// when the value does not fit, e.g. an int beyond 2^53 promoted to float.
+func (v *Visitor) promote(from dataType, to dataType) {
+	if from == to {
+		return
+	}
+	if op, o := conversionOps[[2]dataType{from, to}]; o && (from == DTypeInt || from == DTypeDouble) {
+		v.emit(op)
+	}
 }
 
 func (v *Visitor) visitExpression(node *ExpressionNode) {
-	m := false
+	l, r := inferType(node.Left), inferType(node.Right)
+	if node.Op == EOpADD && (l == DTypeString || r == DTypeString) {
+		v.Accept(node.Left)
+		v.Accept(node.Right)
+		v.emit(Op_sconcat)
+		return
+	}
+	if l == DTypeDecimal || r == DTypeDecimal {
+		v.Accept(node.Left)
+		v.promote(l, DTypeDecimal)
+		v.Accept(node.Right)
+		v.promote(r, DTypeDecimal)
+		switch node.Op {
+		case EOpADD:
+			v.emit(Op_madd)
+		case EOprSUB:
+			v.emit(Op_msub)
+		case EOprMULT:
+			v.emit(Op_mmul)
+		case EOprDIV:
+			v.emit(Op_mdiv)
+		case EOprPOW:
+			v.emit(Op_mexp)
+		}
+		return
+	}
+	if inferType(&Node{Type: AstExpression, Object: node}) == DTypeInt {
+		v.Accept(node.Left)
+		v.Accept(node.Right)
+		switch node.Op {
+		case EOpADD:
+			v.emit(Op_iadd)
+		case EOprSUB:
+			v.emit(Op_isub)
+		case EOprMULT:
+			v.emit(Op_imul)
+		}
+		return
+	}
 	v.Accept(node.Left)
-	if v.state == DTypeString {
-		m = true
+	if r == DTypeDouble {
+		v.promote(l, DTypeDouble)
 	}
-	v.state = -1
 	v.Accept(node.Right)
-	if v.state == DTypeString {
-		m = true
+	if l == DTypeDouble {
+		v.promote(r, DTypeDouble)
 	}
-	v.state = -1
 	switch node.Op {
 	case EOpADD:
-		if m {
-			v.emitter <- consInst(Op_sconcat)
-		} else {
-			v.emitter <- consInst(Op_iadd)
-		}
+		v.emit(Op_dadd)
 	case EOprSUB:
-		v.emitter <- consInst(Op_dsub)
+		v.emit(Op_dsub)
 	case EOprMULT:
-		v.emitter <- consInst(Op_dmul)
+		v.emit(Op_dmul)
 	case EOprDIV:
-		v.emitter <- consInst(Op_ddiv)
+		v.emit(Op_ddiv)
 	case EOprPOW:
-		v.emitter <- consInst(Op_dexp)
+		v.emit(Op_dexp)
 	}
 }
 
+// visitFunction compiles a call. Only the conversion built-ins (int, float,
+// string, bool, char, decimal) exist so far.
+func (v *Visitor) visitFunction(node *FunctionNode) {
+	to, o := conversions[node.Name]
+	if !o {
+		panic(fmt.Errorf("error: unknown function \"%s\"", node.Name))
+	}
+	if len(node.Params) != 1 {
+		panic(fmt.Errorf("error: function \"%s\" expects 1 argument, got %d", node.Name, len(node.Params)))
+	}
+	v.Accept(node.Params[0])
+	from := inferType(node.Params[0])
+	if from == to {
+		return
+	}
+	if op, o := conversionOps[[2]dataType{from, to}]; o {
+		v.emit(op)
+		return
+	}
+	v.emit(Op_conv, int(to))
+}
+
+func (v *Visitor) visitMethod(node *MethodNode) {
+	v.Accept(node.Receiver)
+	for _, param := range node.Params {
+		v.Accept(param)
+	}
+	v.emitConst(Op_invoke, &Data{Type: DTypeMethodRef, Value: &MethodRef{
+		Name: node.Name,
+		Argc: len(node.Params),
+	}})
+}
+
 func (v *Visitor) visitDisjunction(node *DisjunctionNode) {
+	mark := len(v.insts)
 	v.Accept(node.Left)
+	split := len(v.insts)
 	v.Accept(node.Right)
-	v.emitter <- consInst(Op_ior)
+	if !v.opts.NoOptimize && v.simplify(false, mark, split, node.Left, node.Right) {
+		return
+	}
+	v.emit(Op_bor)
 }
 
 func (v *Visitor) visitConjunction(node *ConjunctionNode) {
+	mark := len(v.insts)
 	v.Accept(node.Left)
+	split := len(v.insts)
 	v.Accept(node.Right)
-	v.emitter <- consInst(Op_iand)
+	if !v.opts.NoOptimize && v.simplify(true, mark, split, node.Left, node.Right) {
+		return
+	}
+	v.emit(Op_band)
 }
 
 func (v *Visitor) visitEquality(node *ComparisonNode) {
@@ -111,9 +274,9 @@ func (v *Visitor) visitEquality(node *ComparisonNode) {
 	v.Accept(node.Right)
 	switch node.Op {
 	case OprEqual:
-		v.emitter <- consInst(Op_cmp_eq)
+		v.emit(Op_cmp_eq)
 	case OprNotEqual:
-		v.emitter <- consInst(Op_cmp_ne)
+		v.emit(Op_cmp_ne)
 	}
 }
 
@@ -122,13 +285,13 @@ func (v *Visitor) visitComparison(node *ComparisonNode) {
 	v.Accept(node.Right)
 	switch node.Op {
 	case OprGreaterThan:
-		v.emitter <- consInst(Op_cmp_g)
+		v.emit(Op_cmp_g)
 	case OprGreaterThanEqual:
-		v.emitter <- consInst(Op_cmp_ge)
+		v.emit(Op_cmp_ge)
 	case OprLessThan:
-		v.emitter <- consInst(Op_cmp_l)
+		v.emit(Op_cmp_l)
 	case OprLessThanEqual:
-		v.emitter <- consInst(Op_cmp_le)
+		v.emit(Op_cmp_le)
 	}
 }
 
@@ -138,51 +301,29 @@ func (v *Visitor) visitPrimitive(node *Node) {
 
 func (v *Visitor) visitIdentifier(node *IdentifierNode) {
 	if node.At {
-		Const := &emitted{Type: E_Const}
-		ConstData := &Data{Type: DTypeDataRef, Value: &DataRef{
+		root := v.emitConst(Op_getstatic, &Data{Type: DTypeDataRef, Value: &DataRef{
 			Name: node.Base,
 			Root: DRTypeVMStatic,
-		}}
-		Const.Value = ConstData
-		v.emitter <- Const
-		v.emitter <- consInst(Op_getstatic)
-		v.emitter <- consInst(v.constc)
-		v.constc++
+		}})
 		for _, attr := range node.SubIdentifier {
-			Const := &emitted{Type: E_Const}
-			ConstData := &Data{Type: DTypeAttrRef, Value: &AttrRef{
+			root = v.emitConst(Op_getattr, &Data{Type: DTypeAttrRef, Value: &AttrRef{
 				Name: attr,
-				Root: v.constc - 1,
-			}}
-			Const.Value = ConstData
-			v.emitter <- Const
-			v.emitter <- consInst(Op_getattr)
-			v.emitter <- consInst(v.constc)
-			v.constc++
+				Root: root,
+			}})
 		}
 	}
 }
 
 func (v *Visitor) visitLiteral(node *LiteralNode) {
-	Const := &emitted{Type: E_Const}
 	ConstData := &Data{Value: node.Raw}
-	Const.Value = ConstData
 	switch node.Type {
 	case LSTRING:
 		ConstData.Type = DTypeString
-		v.emitter <- Const
-		v.emitter <- consInst(Op_sload)
-		v.emitter <- consInst(v.constc)
+		v.emitConst(Op_sload, ConstData)
 	case LBOOLEAN:
 		ConstData.Type = DTypeBool
-		f := 0
-		if node.Raw == "true" {
-			f = 1
-		}
-		ConstData.Value = f
-		v.emitter <- Const
-		v.emitter <- consInst(Op_iload)
-		v.emitter <- consInst(v.constc)
+		ConstData.Value = node.Raw == "true"
+		v.emitConst(Op_iload, ConstData)
 	case LNUMBER:
 		ConstData.Type = DTypeDouble
 		f, err := strconv.ParseFloat(node.Raw, 64)
@@ -190,36 +331,77 @@ func (v *Visitor) visitLiteral(node *LiteralNode) {
 			panic(err)
 		}
 		ConstData.Value = f
-		v.emitter <- Const
-		v.emitter <- consInst(Op_dload)
-		v.emitter <- consInst(v.constc)
+		v.emitConst(Op_dload, ConstData)
+	case LDECIMAL:
+		ConstData.Type = DTypeDecimal
+		d, err := ParseDecimal(node.Raw)
+		if err != nil {
+			panic(err)
+		}
+		ConstData.Value = d
+		v.emitConst(Op_mload, ConstData)
 	}
-	v.constc++
 }
 
-func Compile(node *Node) *VM {
-	consts := make([]*Data, 0, 256)
-	insts := make([]int, 0, 256)
+// Compile generates a Program for node with the default Options. Errors raised
+// while generating code, such as calls to unknown functions, are returned
+// instead of panicking.
+func Compile(node *Node) (*Program, error) {
+	return CompileWith(node, Options{
+		DecimalScale:    DefaultDecimalScale,
+		DecimalRounding: RoundHalfEven,
+	})
+}
+
+// CompileWith is Compile with opts. Constant sub-expressions are evaluated
+// once here using opts, so options that change evaluation must be given now
+// rather than set on the Program afterwards.
+func CompileWith(node *Node, opts Options) (program *Program, err error) {
 	visitor := NewVisitor()
-	emit := visitor.Emitter()
-	go visitor.Accept(node)
-	for {
-		obj := <-emit
-		if obj == nil {
-			break
-		}
-		if obj.Type == E_Inst {
-			insts = append(insts, obj.Value.(int))
-		}
-		if obj.Type == E_Const {
-			visitor.state = int(obj.Value.(*Data).Type)
-			consts = append(consts, obj.Value.(*Data))
+	visitor.opts = opts
+	defer func() {
+		if r := recover(); r != nil {
+			err = compileError(r)
+			program = nil
 		}
+	}()
+	visitor.Accept(node)
+	if !opts.NoOptimize {
+		visitor.compact()
 	}
-	return &VM{
-		ConstsPool: consts,
-		Insts:      insts,
-		pc:         0,
-		static:     map[string]static{},
+	return &Program{
+		options: opts,
+		consts:  visitor.consts,
+		insts:   visitor.insts,
+		ranges:  visitor.ranges(),
+		source:  visitor.source,
+	}, nil
+}
+
+// compileError turns a panic raised by the parser or code generator into the
+// error it reports. A Go runtime error is a bug rather than a bad rule and is
+// reported as an InternalError, as Run does, since Debugger.Eval compiles
+// whatever the user types.
+func compileError(r any) error {
+	if e, o := r.(runtime.Error); o {
+		return &RuntimeError{Kind: InternalError, Err: fmt.Errorf("error: %w", e)}
+	}
+	if e, o := r.(error); o {
+		return e
+	}
+	return fmt.Errorf("error: %v", r)
+}
+
+// ranges compresses pos into a table with an entry wherever the source
+// position changes at the start of an instruction.
+func (v *Visitor) ranges() []pcRange {
+	var table []pcRange
+	for pc := 0; pc < len(v.insts); pc += 1 + operands[v.insts[pc]] {
+		r := v.pos[pc]
+		if r.Line == 0 || (len(table) > 0 && table[len(table)-1].Range == r) {
+			continue
+		}
+		table = append(table, pcRange{PC: pc, Range: r})
 	}
+	return table
 }
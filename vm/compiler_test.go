@@ -0,0 +1,44 @@
+package smanchai
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const benchRule = `@user.role.name + "I" == "HII" and @cart.total * 1.2 > 60 * 60 * 24 or @user.age >= 18`
+
+func BenchmarkCompile(b *testing.B) {
+	node := NewParser(NewLexer(strings.NewReader(benchRule))).Parse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Compile(node); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseAndCompile(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		node := NewParser(NewLexer(strings.NewReader(benchRule))).Parse()
+		if _, err := Compile(node); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCompileRuntimePanic(t *testing.T) {
+	// a malformed AST is a bug in the caller or the parser, not a bad rule
+	_, err := Compile(&Node{Type: AstProgram, Object: (*ProgramNode)(nil)})
+	if !errors.Is(err, InternalError) {
+		t.Errorf("got error %v, want %s", err, InternalError)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	node := NewParser(NewLexer(strings.NewReader(`nope(1)`))).Parse()
+	if _, err := Compile(node); err == nil {
+		t.Error("calling an unknown function compiled")
+	}
+}
@@ -0,0 +1,250 @@
+package smanchai
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var dataTypes = []string{
+	DTypeInst:      "inst",
+	DTypeArray:     "array",
+	DTypeInt:       "int",
+	DTypeDouble:    "float",
+	DTypeBool:      "bool",
+	DTypeChar:      "char",
+	DTypeString:    "string",
+	DTypeDataRef:   "dataref",
+	DTypeAttrRef:   "attrref",
+	DTypeMethodRef: "methodref",
+	DTypeObject:    "object",
+	DTypeDecimal:   "decimal",
+	DTypeReflect:   "object",
+}
+
+func (t dataType) String() string {
+	if t < 0 || int(t) >= len(dataTypes) {
+		return fmt.Sprintf("dataType(%d)", int(t))
+	}
+	return dataTypes[t]
+}
+
+// conversions maps the name of a conversion built-in to its target type.
+var conversions = map[string]dataType{
+	"int":     DTypeInt,
+	"float":   DTypeDouble,
+	"string":  DTypeString,
+	"bool":    DTypeBool,
+	"char":    DTypeChar,
+	"decimal": DTypeDecimal,
+}
+
+// conversionOps holds the specialised opcodes the compiler may emit instead of
+// Op_conv when it knows the source type.
+var conversionOps = map[[2]dataType]int{
+	{DTypeInt, DTypeBool}:       Op_i2b,
+	{DTypeInt, DTypeChar}:       Op_i2c,
+	{DTypeInt, DTypeDouble}:     Op_i2d,
+	{DTypeInt, DTypeDecimal}:    Op_i2m,
+	{DTypeDouble, DTypeDecimal}: Op_d2m,
+	{DTypeDecimal, DTypeDouble}: Op_m2d,
+}
+
+// maxExactFloat is the largest magnitude below which every integer is exactly
+// representable as a float64.
+const maxExactFloat = 1 << 53
+
+// Convert returns d converted to type to. Conversions that would lose
+// information (a fractional part, an out of range value, an unparsable string)
+// fail instead of silently truncating.
+//
+// A decimal converts to float only when the float prints back as the same
+// decimal.
+//
+// Strings are parsed strictly: "42" converts to int, "3.5" to float and
+// decimal, "true"/"false" to bool, and a single character to char.
+// Numbers convert to bool by comparing against zero, and bool converts to
+// 1/0. Arrays and objects cannot be converted.
+func Convert(d *Data, to dataType) (*Data, error) {
+	if d == nil {
+		return nil, newError(TypeMismatch, "error: cannot convert undefined to %s", to)
+	}
+	if d.Type == to {
+		return d, nil
+	}
+	fail := func() (*Data, error) {
+		return nil, newError(TypeMismatch, "error: cannot convert %s %s to %s", d.Type, quoteData(d), to)
+	}
+	switch d.Type {
+	case DTypeInt:
+		i, ok := intValue(d.Value)
+		if !ok {
+			return fail()
+		}
+		switch to {
+		case DTypeDouble:
+			if i > maxExactFloat || i < -maxExactFloat {
+				return fail()
+			}
+			return &Data{Type: DTypeDouble, Value: float64(i)}, nil
+		case DTypeBool:
+			return newBool(i != 0), nil
+		case DTypeChar:
+			if i < 0 || i > utf8.MaxRune || !utf8.ValidRune(rune(i)) {
+				return fail()
+			}
+			return &Data{Type: DTypeChar, Value: rune(i)}, nil
+		case DTypeString:
+			return &Data{Type: DTypeString, Value: strconv.FormatInt(i, 10)}, nil
+		case DTypeDecimal:
+			return &Data{Type: DTypeDecimal, Value: NewDecimalFromInt(i)}, nil
+		}
+	case DTypeDouble:
+		f := toFloat64(d.Value)
+		switch to {
+		case DTypeInt:
+			if f != math.Trunc(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+				return fail()
+			}
+			return &Data{Type: DTypeInt, Value: int(f)}, nil
+		case DTypeBool:
+			return newBool(f != 0), nil
+		case DTypeChar:
+			if f != math.Trunc(f) || f < 0 || f > utf8.MaxRune || !utf8.ValidRune(rune(f)) {
+				return fail()
+			}
+			return &Data{Type: DTypeChar, Value: rune(f)}, nil
+		case DTypeString:
+			return &Data{Type: DTypeString, Value: strconv.FormatFloat(f, 'g', -1, 64)}, nil
+		case DTypeDecimal:
+			m, err := NewDecimalFromFloat(f)
+			if err != nil {
+				return fail()
+			}
+			return &Data{Type: DTypeDecimal, Value: m}, nil
+		}
+	case DTypeBool:
+		b := boolValue(d.Value)
+		i := 0
+		if b {
+			i = 1
+		}
+		switch to {
+		case DTypeInt:
+			return &Data{Type: DTypeInt, Value: i}, nil
+		case DTypeDouble:
+			return &Data{Type: DTypeDouble, Value: float64(i)}, nil
+		case DTypeString:
+			return &Data{Type: DTypeString, Value: strconv.FormatBool(b)}, nil
+		case DTypeDecimal:
+			return &Data{Type: DTypeDecimal, Value: NewDecimalFromInt(int64(i))}, nil
+		}
+	case DTypeChar:
+		c, ok := intValue(d.Value)
+		if !ok {
+			return fail()
+		}
+		switch to {
+		case DTypeInt:
+			return &Data{Type: DTypeInt, Value: int(c)}, nil
+		case DTypeDouble:
+			return &Data{Type: DTypeDouble, Value: float64(c)}, nil
+		case DTypeString:
+			return &Data{Type: DTypeString, Value: string(rune(c))}, nil
+		case DTypeDecimal:
+			return &Data{Type: DTypeDecimal, Value: NewDecimalFromInt(c)}, nil
+		}
+	case DTypeString:
+		s := d.Value.(string)
+		switch to {
+		case DTypeInt:
+			i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 0)
+			if err != nil {
+				return fail()
+			}
+			return &Data{Type: DTypeInt, Value: int(i)}, nil
+		case DTypeDouble:
+			f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return fail()
+			}
+			return &Data{Type: DTypeDouble, Value: f}, nil
+		case DTypeBool:
+			switch strings.TrimSpace(s) {
+			case "true":
+				return newBool(true), nil
+			case "false":
+				return newBool(false), nil
+			}
+		case DTypeChar:
+			if utf8.RuneCountInString(s) != 1 {
+				return fail()
+			}
+			c, _ := utf8.DecodeRuneInString(s)
+			return &Data{Type: DTypeChar, Value: c}, nil
+		case DTypeDecimal:
+			m, err := ParseDecimal(strings.TrimSpace(s))
+			if err != nil {
+				return fail()
+			}
+			return &Data{Type: DTypeDecimal, Value: m}, nil
+		}
+	case DTypeDecimal:
+		m := d.Value.(Decimal)
+		switch to {
+		case DTypeInt:
+			i, exact := m.Int64()
+			if !exact || int64(int(i)) != i {
+				return fail()
+			}
+			return &Data{Type: DTypeInt, Value: int(i)}, nil
+		case DTypeDouble:
+			// lossless when the shortest form of the float reads back as m,
+			// so 0.1m converts but 0.1000000000000000001m does not
+			f, _ := m.Float64()
+			if back, err := NewDecimalFromFloat(f); err != nil || !back.Equal(m) {
+				return fail()
+			}
+			return &Data{Type: DTypeDouble, Value: f}, nil
+		case DTypeBool:
+			return newBool(m.Sign() != 0), nil
+		case DTypeString:
+			return &Data{Type: DTypeString, Value: m.String()}, nil
+		}
+	}
+	return fail()
+}
+
+func newBool(b bool) *Data {
+	return &Data{Type: DTypeBool, Value: b}
+}
+
+func boolValue(value any) bool {
+	v, _ := value.(bool)
+	return v
+}
+
+func intValue(value any) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(u), true
+	}
+	return 0, false
+}
+
+func quoteData(d *Data) string {
+	if d.Type == DTypeString {
+		return strconv.Quote(d.Value.(string))
+	}
+	return fmt.Sprint(d.Value)
+}
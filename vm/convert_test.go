@@ -0,0 +1,73 @@
+package smanchai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func compile(t *testing.T, src string) *Program {
+	t.Helper()
+	p, err := Compile(NewParser(NewLexer(strings.NewReader(src))).Parse())
+	if err != nil {
+		t.Fatalf("compile %q: %v", src, err)
+	}
+	return p
+}
+
+func TestConvertLossy(t *testing.T) {
+	tests := []struct {
+		in   *Data
+		to   dataType
+		want string // "" when the conversion must fail
+	}{
+		{&Data{Type: DTypeDecimal, Value: MustParseDecimal("0.1")}, DTypeDouble, "0.1"},
+		{&Data{Type: DTypeDecimal, Value: MustParseDecimal("-2.5")}, DTypeDouble, "-2.5"},
+		{&Data{Type: DTypeDecimal, Value: MustParseDecimal("0.1000000000000000001")}, DTypeDouble, ""},
+		{&Data{Type: DTypeDecimal, Value: MustParseDecimal("1" + strings.Repeat("0", 400))}, DTypeDouble, ""},
+		{&Data{Type: DTypeInt, Value: 1 << 53}, DTypeDouble, "9.007199254740992e+15"},
+		{&Data{Type: DTypeInt, Value: 1<<53 + 1}, DTypeDouble, ""},
+		{&Data{Type: DTypeDouble, Value: 2.5}, DTypeInt, ""},
+		{&Data{Type: DTypeString, Value: "42"}, DTypeInt, "42"},
+		{&Data{Type: DTypeString, Value: "4x"}, DTypeInt, ""},
+	}
+	for _, tt := range tests {
+		r, err := Convert(tt.in, tt.to)
+		if tt.want == "" {
+			if !errors.Is(err, TypeMismatch) {
+				t.Errorf("Convert(%s, %s) = %v, %v, want a TypeMismatch", tt.in, tt.to, r, err)
+			}
+			continue
+		}
+		if err != nil || r.String() != tt.want {
+			t.Errorf("Convert(%s, %s) = %v, %v, want %s", tt.in, tt.to, r, err, tt.want)
+		}
+	}
+}
+
+func TestConvertBuiltins(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // "" when the run must fail
+	}{
+		{`float(0.1m)`, "0.1"},
+		{`float(1.2345678912345678912m)`, ""},
+		{`int(2.9m)`, ""},
+		{`int("9999999999999999") + 0.5`, ""},
+		{`int("9999") + 0.5`, "9999.5"},
+		{`1 + 0.5`, "1.5"},
+	}
+	for _, tt := range tests {
+		r, err := compile(t, tt.src).Run(context.Background(), nil)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s = %v, want an error", tt.src, r)
+			}
+			continue
+		}
+		if err != nil || r.String() != tt.want {
+			t.Errorf("%s = %v, %v, want %s", tt.src, r, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package smanchai
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func NewObject() *Data {
+	return &Data{Type: DTypeObject, Value: &DataObjectMap{Data: map[string]*Data{}}}
+}
+
+func NewArray(items ...*Data) *Data {
+	return &Data{Type: DTypeArray, Value: &DataObjectArray{Data: append([]*Data{}, items...)}}
+}
+
+func NewString(v string) *Data {
+	return &Data{Type: DTypeString, Value: v}
+}
+
+func NewInt(v int) *Data {
+	return &Data{Type: DTypeInt, Value: v}
+}
+
+func NewFloat(v float64) *Data {
+	return &Data{Type: DTypeDouble, Value: v}
+}
+
+func NewBool(v bool) *Data {
+	return newBool(v)
+}
+
+func NewChar(v rune) *Data {
+	return &Data{Type: DTypeChar, Value: v}
+}
+
+func NewDecimal(v Decimal) *Data {
+	return &Data{Type: DTypeDecimal, Value: v}
+}
+
+// Put sets attribute key of an object and returns the object, for chaining:
+//
+//	NewObject().Put("name", NewString("admin")).Put("level", NewInt(3))
+//
+// It panics if t is not an object.
+func (t *Data) Put(key string, v *Data) *Data {
+	omap := t.object()
+	if _, o := omap.Data[key]; !o {
+		omap.Keys = append(omap.Keys, key)
+	}
+	omap.Data[key] = v
+	return t
+}
+
+// Append adds items to an array and returns the array. It panics if t is not
+// an array.
+func (t *Data) Append(items ...*Data) *Data {
+	if t == nil || t.Type != DTypeArray {
+		panic(fmt.Sprintf("smanchai: Append on %s", typeName(t)))
+	}
+	arr := t.Value.(*DataObjectArray)
+	arr.Data = append(arr.Data, items...)
+	return t
+}
+
+func (t *Data) object() *DataObjectMap {
+	if t == nil || t.Type != DTypeObject {
+		panic(fmt.Sprintf("smanchai: Put on %s", typeName(t)))
+	}
+	return t.Value.(*DataObjectMap)
+}
+
+func typeName(t *Data) string {
+	if t == nil {
+		return "undefined"
+	}
+	return t.Type.String()
+}
+
+// Get follows a dot separated path of attribute names and array indices, like
+// "role.name" or "roles.0", and returns nil when any part is missing.
+func (t *Data) Get(path string) *Data {
+	cur := t
+	for _, name := range splitPath(path) {
+		cur = cur.attr(name)
+		if cur == nil {
+			return nil
+		}
+	}
+	return cur
+}
+
+func (t *Data) attr(name string) *Data {
+	if t == nil {
+		return nil
+	}
+	switch t.Type {
+	case DTypeObject:
+		return t.Value.(*DataObjectMap).Data[name]
+	case DTypeArray:
+		arr := t.Value.(*DataObjectArray).Data
+		i, err := strconv.Atoi(name)
+		if err != nil || i < 0 || i >= len(arr) {
+			return nil
+		}
+		return arr[i]
+	case DTypeReflect:
+		v, err := t.Value.(*DataReflect).Attr(name)
+		if err != nil {
+			return nil
+		}
+		return v
+	}
+	return nil
+}
+
+// Set stores v at path, creating missing intermediate objects. Array indices
+// must already exist.
+func (t *Data) Set(path string, v *Data) error {
+	names := splitPath(path)
+	if len(names) == 0 {
+		return fmt.Errorf("error: empty path")
+	}
+	cur := t
+	for i, name := range names {
+		last := i == len(names)-1
+		switch {
+		case cur != nil && cur.Type == DTypeObject:
+			omap := cur.Value.(*DataObjectMap)
+			if last {
+				cur.Put(name, v)
+				return nil
+			}
+			next := omap.Data[name]
+			if next == nil {
+				next = NewObject()
+				cur.Put(name, next)
+			}
+			cur = next
+		case cur != nil && cur.Type == DTypeArray:
+			arr := cur.Value.(*DataObjectArray).Data
+			idx, err := strconv.Atoi(name)
+			if err != nil || idx < 0 || idx >= len(arr) {
+				return fmt.Errorf("error: index \"%s\" out of range at %s", name, strings.Join(names[:i+1], "."))
+			}
+			if last {
+				arr[idx] = v
+				return nil
+			}
+			if arr[idx] == nil {
+				arr[idx] = NewObject()
+			}
+			cur = arr[idx]
+		default:
+			return fmt.Errorf("error: cannot set attribute of %s at %s", typeName(cur), strings.Join(names[:i], "."))
+		}
+	}
+	return nil
+}
+
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// Equal reports whether t and o have the same type and deeply equal values.
+// Object key order is ignored. Unlike ==, 1 and 1.0 are not Equal since their
+// types differ.
+func (t *Data) Equal(o *Data) bool {
+	t, err := eager(t)
+	if err != nil {
+		return false
+	}
+	o, err = eager(o)
+	if err != nil {
+		return false
+	}
+	if t == nil || o == nil {
+		return t == nil && o == nil
+	}
+	if t.Type != o.Type {
+		return false
+	}
+	switch t.Type {
+	case DTypeArray:
+		a, b := t.Value.(*DataObjectArray).Data, o.Value.(*DataObjectArray).Data
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !a[i].Equal(b[i]) {
+				return false
+			}
+		}
+		return true
+	case DTypeObject:
+		a, b := t.Value.(*DataObjectMap).Data, o.Value.(*DataObjectMap).Data
+		if len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !v.Equal(w) {
+				return false
+			}
+		}
+		return true
+	case DTypeInt, DTypeChar, DTypeInst:
+		x, _ := intValue(t.Value)
+		y, _ := intValue(o.Value)
+		return x == y
+	case DTypeDouble:
+		return toFloat64(t.Value) == toFloat64(o.Value)
+	case DTypeBool:
+		return boolValue(t.Value) == boolValue(o.Value)
+	case DTypeDecimal:
+		return t.Value.(Decimal).Equal(o.Value.(Decimal))
+	case DTypeDataRef:
+		return *t.Value.(*DataRef) == *o.Value.(*DataRef)
+	case DTypeAttrRef:
+		return *t.Value.(*AttrRef) == *o.Value.(*AttrRef)
+	case DTypeMethodRef:
+		return *t.Value.(*MethodRef) == *o.Value.(*MethodRef)
+	}
+	return t.Value == o.Value
+}
+
+// Clone returns a deep copy of t. Lazy values are shared since they are never
+// modified through Data.
+func (t *Data) Clone() *Data {
+	if t == nil {
+		return nil
+	}
+	switch t.Type {
+	case DTypeArray:
+		arr := t.Value.(*DataObjectArray).Data
+		out := make([]*Data, len(arr))
+		for i, v := range arr {
+			out[i] = v.Clone()
+		}
+		return &Data{Type: DTypeArray, Value: &DataObjectArray{Data: out}}
+	case DTypeObject:
+		omap := t.Value.(*DataObjectMap)
+		out := &DataObjectMap{
+			Data: make(map[string]*Data, len(omap.Data)),
+			Keys: append([]string(nil), omap.Keys...),
+		}
+		for k, v := range omap.Data {
+			out.Data[k] = v.Clone()
+		}
+		return &Data{Type: DTypeObject, Value: out}
+	case DTypeDataRef:
+		v := *t.Value.(*DataRef)
+		return &Data{Type: t.Type, Value: &v}
+	case DTypeAttrRef:
+		v := *t.Value.(*AttrRef)
+		return &Data{Type: t.Type, Value: &v}
+	case DTypeMethodRef:
+		v := *t.Value.(*MethodRef)
+		return &Data{Type: t.Type, Value: &v}
+	}
+	return &Data{Type: t.Type, Value: t.Value}
+}
+
+// Pretty formats t like String but puts every array item and object attribute
+// on its own indented line.
+func (t *Data) Pretty() string {
+	var b strings.Builder
+	formatData(&b, t, "  ", 0)
+	return b.String()
+}
+
+// formatData writes t in a JSON-like notation: strings and chars are quoted,
+// object attributes follow OrderedKeys and nil prints as undefined. With an
+// empty indent everything stays on one line.
+func formatData(b *strings.Builder, t *Data, indent string, depth int) {
+	t, err := eager(t)
+	if err != nil {
+		b.WriteString("<" + err.Error() + ">")
+		return
+	}
+	if t == nil {
+		b.WriteString("undefined")
+		return
+	}
+	newline := func(depth int) {
+		if indent == "" {
+			return
+		}
+		b.WriteByte('\n')
+		b.WriteString(strings.Repeat(indent, depth))
+	}
+	sep := ", "
+	if indent != "" {
+		sep = ","
+	}
+	switch t.Type {
+	case DTypeArray:
+		arr := t.Value.(*DataObjectArray).Data
+		if len(arr) == 0 {
+			b.WriteString("[]")
+			return
+		}
+		b.WriteByte('[')
+		for i, v := range arr {
+			if i > 0 {
+				b.WriteString(sep)
+			}
+			newline(depth + 1)
+			formatData(b, v, indent, depth+1)
+		}
+		newline(depth)
+		b.WriteByte(']')
+	case DTypeObject:
+		omap := t.Value.(*DataObjectMap)
+		if len(omap.Data) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		b.WriteByte('{')
+		for i, k := range omap.OrderedKeys() {
+			if i > 0 {
+				b.WriteString(sep)
+			}
+			newline(depth + 1)
+			b.WriteString(strconv.Quote(k))
+			b.WriteString(": ")
+			formatData(b, omap.Data[k], indent, depth+1)
+		}
+		newline(depth)
+		b.WriteByte('}')
+	case DTypeString:
+		b.WriteString(strconv.Quote(t.Value.(string)))
+	case DTypeChar:
+		c, _ := intValue(t.Value)
+		b.WriteString(strconv.QuoteRune(rune(c)))
+	default:
+		b.WriteString(t.String())
+	}
+}
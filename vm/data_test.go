@@ -0,0 +1,145 @@
+package smanchai
+
+import "testing"
+
+func sampleData() *Data {
+	return NewObject().
+		Put("name", NewString("ann \"a\"")).
+		Put("roles", NewArray(NewString("admin"), NewObject().Put("level", NewInt(3)), nil)).
+		Put("limit", NewDecimal(MustParseDecimal("12.50"))).
+		Put("initial", NewChar('a')).
+		Put("empty", NewObject()).
+		Put("list", NewArray()).
+		Put("ok", NewBool(true)).
+		Put("ratio", NewFloat(0.5))
+}
+
+func TestCloneIsDeep(t *testing.T) {
+	orig := sampleData()
+	want := orig.String()
+	c := orig.Clone()
+	if !c.Equal(orig) || c.String() != want {
+		t.Fatalf("clone %s differs from %s", c, want)
+	}
+	c.Set("roles.1.level", NewInt(9))
+	c.Get("roles").Append(NewInt(1))
+	c.Put("extra", NewInt(1))
+	c.Get("empty").Put("k", NewString("v"))
+	c.Get("roles").Value.(*DataObjectArray).Data[0].Value = "changed"
+	if got := orig.String(); got != want {
+		t.Errorf("changing the clone changed the original to\n%s\nwant\n%s", got, want)
+	}
+
+	refs := []*Data{
+		{Type: DTypeDataRef, Value: &DataRef{Name: "user", Root: DRTypeVMStatic}},
+		{Type: DTypeAttrRef, Value: &AttrRef{Name: "name", Root: 1}},
+		{Type: DTypeMethodRef, Value: &MethodRef{Name: "Has", Argc: 1}},
+	}
+	for _, r := range refs {
+		c := r.Clone()
+		switch v := c.Value.(type) {
+		case *DataRef:
+			v.Name = "x"
+		case *AttrRef:
+			v.Name = "x"
+		case *MethodRef:
+			v.Argc = 5
+		}
+		if r.Equal(c) {
+			t.Errorf("%s shares its reference with the clone", typeName(r))
+		}
+	}
+	if (*Data)(nil).Clone() != nil {
+		t.Errorf("clone of undefined is not undefined")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	m := func(s string) *Data { return NewDecimal(MustParseDecimal(s)) }
+	tests := []struct {
+		a, b *Data
+		want bool
+	}{
+		{nil, nil, true},
+		{nil, NewInt(0), false},
+		{NewInt(1), NewInt(1), true},
+		{NewInt(1), m("1"), false},
+		{NewInt(1), NewFloat(1), false},
+		{m("1.50"), m("1.5"), true},
+		{m("0.1"), NewFloat(0.1), false},
+		{NewChar('a'), NewString("a"), false},
+		{NewChar('a'), NewChar('a'), true},
+		{NewBool(false), NewInt(0), false},
+		{NewArray(NewInt(1), m("2")), NewArray(NewInt(1), m("2.0")), true},
+		{NewArray(NewInt(1), m("2")), NewArray(NewInt(1), NewInt(2)), false},
+		{NewArray(NewInt(1)), NewArray(NewInt(1), nil), false},
+		{NewArray(nil), NewArray(nil), true},
+		{NewObject().Put("a", NewInt(1)).Put("b", NewArray()), NewObject().Put("b", NewArray()).Put("a", NewInt(1)), true},
+		{NewObject().Put("a", nil), NewObject().Put("b", nil), false},
+		{NewObject().Put("a", NewObject().Put("x", m("1"))), NewObject().Put("a", NewObject().Put("x", NewInt(1))), false},
+		{sampleData(), sampleData(), true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Equal(tt.b); got != tt.want {
+			t.Errorf("%s Equal %s = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := tt.b.Equal(tt.a); got != tt.want {
+			t.Errorf("%s Equal %s = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestEqualLazy(t *testing.T) {
+	type user struct {
+		Name  string
+		Roles []string
+	}
+	l, err := Lazy(user{Name: "ann", Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewObject().Put("Name", NewString("ann")).Put("Roles", NewArray(NewString("admin")))
+	if !l.Equal(want) || !want.Equal(l) {
+		t.Errorf("%s is not Equal to %s", l, want)
+	}
+}
+
+func TestPretty(t *testing.T) {
+	want := `{
+  "name": "ann \"a\"",
+  "roles": [
+    "admin",
+    {
+      "level": 3
+    },
+    undefined
+  ],
+  "limit": 12.5,
+  "initial": 'a',
+  "empty": {},
+  "list": [],
+  "ok": true,
+  "ratio": 0.5
+}`
+	if got := sampleData().Pretty(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got, want := sampleData().String(), `{"name": "ann \"a\"", "roles": ["admin", {"level": 3}, undefined], "limit": 12.5, "initial": 'a', "empty": {}, "list": [], "ok": true, "ratio": 0.5}`; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	short := []struct {
+		d    *Data
+		want string
+	}{
+		{nil, "undefined"},
+		{NewInt(-1), "-1"},
+		{NewString("s"), `"s"`},
+		{NewArray(), "[]"},
+		{NewObject(), "{}"},
+	}
+	for _, tt := range short {
+		if got := tt.d.Pretty(); got != tt.want {
+			t.Errorf("Pretty(%s) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package smanchai
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Debugger runs a Program one instruction at a time, for stepping through a
+// rule that gives an unexpected result:
+//
+//	d := program.Debug(ctx, env)
+//	d.BreakAt(node.Range)
+//	for d.Continue() {
+//		fmt.Println(d.PC(), d.Op(), d.Stack())
+//	}
+//	result, err := d.Result()
+//
+// A Debugger is not safe for concurrent use.
+type Debugger struct {
+	vm      *VM
+	trace   Tracer // tracer passed to Debug, told about every step
+	breaks  map[int]bool
+	statics map[string]*Data
+	watches []watch
+	result  *Data
+	err     error
+	done    bool
+	paused  bool // has run and stopped before d.vm.pc
+}
+
+type watch struct {
+	expr    string
+	program *Program
+}
+
+// WatchValue is the value of a watch expression at the current instruction.
+type WatchValue struct {
+	Expr  string
+	Value *Data
+	Err   error
+}
+
+// Debug prepares a Run of p against env that is driven by the returned
+// Debugger. Nothing runs until Step, StepOver or Continue is called.
+func (p *Program) Debug(ctx context.Context, env *Env, opts ...RunOption) *Debugger {
+	d := &Debugger{
+		breaks:  map[int]bool{},
+		statics: map[string]*Data{},
+		done:    len(p.insts) == 0,
+	}
+	d.vm = &VM{Program: p, ctx: ctx, env: env}
+	for _, opt := range opts {
+		opt(d.vm)
+	}
+	d.trace, d.vm.tracer = d.vm.tracer, debugTracer{d}
+	return d
+}
+
+// Break sets a breakpoint before the instruction at pc.
+func (d *Debugger) Break(pc int) {
+	d.breaks[pc] = true
+}
+
+// BreakAt sets a breakpoint before the first instruction of every run of
+// instructions compiled from inside r, such as the Range of a Node, and
+// returns how many it set.
+func (d *Debugger) BreakAt(r Range) int {
+	n, inside := 0, false
+	for pc := 0; pc < len(d.vm.insts); pc += 1 + operands[d.vm.insts[pc]] {
+		at, o := d.vm.rangeAt(pc)
+		in := o && contains(r, at)
+		if in && !inside {
+			d.breaks[pc] = true
+			n++
+		}
+		inside = in
+	}
+	return n
+}
+
+// Clear removes the breakpoint at pc.
+func (d *Debugger) Clear(pc int) {
+	delete(d.breaks, pc)
+}
+
+// Step runs one instruction and reports whether the run has more to do.
+func (d *Debugger) Step() bool {
+	if d.done {
+		return false
+	}
+	d.paused = true
+	if err := d.advance(); err != nil {
+		d.done, d.result, d.err = true, nil, d.vm.errorAt(err)
+		if d.trace != nil {
+			d.trace.OnError(d.err)
+		}
+	}
+	return !d.done
+}
+
+// StepOver runs the rest of the sub-expression the next instruction was
+// compiled from, stopping before the first instruction from outside it or at
+// a breakpoint. It reports whether the run has more to do.
+func (d *Debugger) StepOver() bool {
+	r, o := d.Range()
+	if !d.Step() {
+		return false
+	}
+	for o {
+		next, ok := d.Range()
+		if !ok || !contains(r, next) || d.breaks[d.vm.pc] {
+			break
+		}
+		if !d.Step() {
+			return false
+		}
+	}
+	return true
+}
+
+// Continue runs until the next breakpoint and reports whether it stopped at
+// one; false means the run finished. Only the breakpoint the Debugger is
+// stopped at is passed over, so one before the first instruction is hit too.
+func (d *Debugger) Continue() bool {
+	if !d.paused && !d.done && d.breaks[d.vm.pc] {
+		d.paused = true
+		return true
+	}
+	for d.Step() {
+		if d.breaks[d.vm.pc] {
+			return true
+		}
+	}
+	return false
+}
+
+// advance runs one instruction and finishes the run after the last one.
+func (d *Debugger) advance() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	if err := d.vm.step(); err != nil {
+		return err
+	}
+	if d.vm.pc < len(d.vm.insts) {
+		return nil
+	}
+	d.done = true
+	d.result, err = d.vm.result()
+	return err
+}
+
+// Done reports whether the run has finished.
+func (d *Debugger) Done() bool {
+	return d.done
+}
+
+// Result returns what Program.Run would have returned, once Done.
+func (d *Debugger) Result() (*Data, error) {
+	return d.result, d.err
+}
+
+// PC returns the pc of the next instruction.
+func (d *Debugger) PC() int {
+	return d.vm.pc
+}
+
+// Op returns the next instruction, Op once the run has finished.
+func (d *Debugger) Op() Opcode {
+	if d.vm.pc >= len(d.vm.insts) {
+		return Op
+	}
+	return Opcode(d.vm.insts[d.vm.pc])
+}
+
+// Range returns the source position the next instruction was compiled from.
+func (d *Debugger) Range() (Range, bool) {
+	if d.done {
+		return Range{}, false
+	}
+	return d.vm.rangeAt(d.vm.pc)
+}
+
+// Stack returns a copy of the operand stack, top last.
+func (d *Debugger) Stack() []*Data {
+	return append([]*Data(nil), d.vm.operand.arr...)
+}
+
+// Statics returns the statics read so far by name. Rules have no local
+// variables; statics are the only named values a run holds.
+func (d *Debugger) Statics() map[string]*Data {
+	statics := make(map[string]*Data, len(d.statics))
+	for k, v := range d.statics {
+		statics[k] = v
+	}
+	return statics
+}
+
+// Eval compiles src with the options of the debugged program and runs it
+// against the same context and Env. Statics are read again, not taken from
+// the debugged run.
+func (d *Debugger) Eval(src string) (*Data, error) {
+	p, err := d.compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return d.eval(p)
+}
+
+// Watch adds an expression that Watches evaluates at every call.
+func (d *Debugger) Watch(src string) error {
+	p, err := d.compile(src)
+	if err != nil {
+		return err
+	}
+	d.watches = append(d.watches, watch{expr: src, program: p})
+	return nil
+}
+
+// Watches evaluates every watch expression, in the order they were added.
+func (d *Debugger) Watches() []WatchValue {
+	values := make([]WatchValue, len(d.watches))
+	for i, w := range d.watches {
+		v, err := d.eval(w.program)
+		values[i] = WatchValue{Expr: w.expr, Value: v, Err: err}
+	}
+	return values
+}
+
+// eval runs p without the tracer of the debugged run, which should not see
+// watch expressions.
+func (d *Debugger) eval(p *Program) (*Data, error) {
+	return p.Run(d.vm.ctx, d.vm.env)
+}
+
+func (d *Debugger) compile(src string) (program *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = compileError(r)
+			program = nil
+		}
+	}()
+	node := NewParser(NewLexer(strings.NewReader(src))).Parse()
+	return CompileWith(node, d.vm.options)
+}
+
+// contains reports whether b lies inside a. A Range without a span, as
+// Assemble makes, only contains the same position.
+func contains(a, b Range) bool {
+	if a.End <= a.Index {
+		return a.Line == b.Line && a.Column == b.Column
+	}
+	return b.Index >= a.Index && b.End <= a.End
+}
+
+// debugTracer records the statics a debugged run reads and passes every event
+// on to the tracer passed to Debug.
+type debugTracer struct {
+	d *Debugger
+}
+
+func (t debugTracer) OnInstruction(pc int, op Opcode, stack []*Data) {
+	if t.d.trace != nil {
+		t.d.trace.OnInstruction(pc, op, stack)
+	}
+}
+
+func (t debugTracer) OnStatic(name string, value *Data, dur time.Duration) {
+	t.d.statics[name] = value
+	if t.d.trace != nil {
+		t.d.trace.OnStatic(name, value, dur)
+	}
+}
+
+func (t debugTracer) OnCall(fn string, args []*Data, result *Data) {
+	if t.d.trace != nil {
+		t.d.trace.OnCall(fn, args, result)
+	}
+}
+
+func (t debugTracer) OnError(err error) {
+	if t.d.trace != nil {
+		t.d.trace.OnError(err)
+	}
+}
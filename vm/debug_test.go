@@ -0,0 +1,76 @@
+package smanchai
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func debugEnv() *Env {
+	env := NewEnv()
+	env.Set("a", StaticValue(NewFloat(2)))
+	env.Set("b", StaticValue(NewFloat(1)))
+	return env
+}
+
+// stops lists the pcs Continue stops at until the run finishes.
+func stops(d *Debugger) []int {
+	var pcs []int
+	for d.Continue() {
+		pcs = append(pcs, d.PC())
+	}
+	return pcs
+}
+
+func TestDebuggerContinue(t *testing.T) {
+	p := compile(t, `@a + 1 > @b`)
+	tests := []struct {
+		name   string
+		breaks []int
+		steps  int
+		want   []int
+	}{
+		{"first instruction", []int{0}, 0, []int{0}},
+		{"consecutive instructions", []int{0, 2, 4}, 0, []int{0, 2, 4}},
+		{"stopped by a step", []int{2, 4}, 1, []int{4}},
+		{"none", nil, 0, nil},
+	}
+	for _, tt := range tests {
+		d := p.Debug(context.Background(), debugEnv())
+		for _, pc := range tt.breaks {
+			d.Break(pc)
+		}
+		for i := 0; i < tt.steps; i++ {
+			d.Step()
+		}
+		if got := stops(d); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: stopped at %v, want %v", tt.name, got, tt.want)
+		}
+		if r, err := d.Result(); err != nil || !r.Equal(NewBool(true)) {
+			t.Errorf("%s: got %s, %v", tt.name, r, err)
+		}
+	}
+}
+
+func TestDebuggerBreakAt(t *testing.T) {
+	src := `@a + 1 > @b`
+	node := NewParser(NewLexer(strings.NewReader(src))).Parse()
+	p, err := Compile(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmp := node.Object.(*ProgramNode).Children[0]
+	left := cmp.Object.(*ComparisonNode).Left
+
+	d := p.Debug(context.Background(), debugEnv())
+	if n := d.BreakAt(left.Range); n != 1 {
+		t.Fatalf("BreakAt set %d breakpoints, want 1", n)
+	}
+	if !d.Continue() || d.PC() != 0 || d.Op() != Op_getstatic {
+		t.Fatalf("stopped at %d %s, want 0 getstatic", d.PC(), d.Op())
+	}
+	if d.Continue() {
+		t.Errorf("stopped again at %d", d.PC())
+	}
+}
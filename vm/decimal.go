@@ -0,0 +1,217 @@
+package smanchai
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type RoundingMode int
+
+const (
+	RoundHalfEven = iota // banker's rounding
+	RoundHalfUp          // ties away from zero
+	RoundHalfDown        // ties toward zero
+	RoundUp              // away from zero
+	RoundDown            // toward zero (truncate)
+	RoundCeiling         // toward +inf
+	RoundFloor           // toward -inf
+)
+
+var roundingModes = []string{
+	RoundHalfEven: "RoundHalfEven",
+	RoundHalfUp:   "RoundHalfUp",
+	RoundHalfDown: "RoundHalfDown",
+	RoundUp:       "RoundUp",
+	RoundDown:     "RoundDown",
+	RoundCeiling:  "RoundCeiling",
+	RoundFloor:    "RoundFloor",
+}
+
+func (m RoundingMode) String() string {
+	if m < 0 || int(m) >= len(roundingModes) {
+		return fmt.Sprintf("RoundingMode(%d)", int(m))
+	}
+	return roundingModes[m]
+}
+
+// DefaultDecimalScale is the number of fractional digits kept when a decimal
+// operation (division, negative exponent) does not terminate.
+const DefaultDecimalScale = 16
+
+// Decimal is an exact, arbitrary-precision decimal number. The zero value is 0.
+// Decimals are immutable; every operation returns a new value.
+type Decimal struct {
+	rat *big.Rat
+}
+
+func NewDecimalFromInt(v int64) Decimal {
+	return Decimal{rat: new(big.Rat).SetInt64(v)}
+}
+
+// NewDecimalFromFloat converts v using its shortest decimal representation,
+// so 0.1 becomes exactly 0.1 rather than 0.1000000000000000055511151231257827.
+func NewDecimalFromFloat(v float64) (Decimal, error) {
+	return ParseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+func ParseDecimal(s string) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.Contains(s, "/") {
+		return Decimal{}, fmt.Errorf("error: invalid decimal \"%s\"", s)
+	}
+	return Decimal{rat: r}, nil
+}
+
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (d Decimal) get() *big.Rat {
+	if d.rat == nil {
+		return new(big.Rat)
+	}
+	return d.rat
+}
+
+func (d Decimal) Add(o Decimal) Decimal {
+	return Decimal{rat: new(big.Rat).Add(d.get(), o.get())}
+}
+
+func (d Decimal) Sub(o Decimal) Decimal {
+	return Decimal{rat: new(big.Rat).Sub(d.get(), o.get())}
+}
+
+func (d Decimal) Mul(o Decimal) Decimal {
+	return Decimal{rat: new(big.Rat).Mul(d.get(), o.get())}
+}
+
+// Quo divides d by o and rounds the result to scale fractional digits.
+func (d Decimal) Quo(o Decimal, scale int, mode RoundingMode) (Decimal, error) {
+	if o.Sign() == 0 {
+		return Decimal{}, newError(DivisionByZero, "error: decimal division by zero")
+	}
+	return Decimal{rat: new(big.Rat).Quo(d.get(), o.get())}.Round(scale, mode), nil
+}
+
+// Mod returns the remainder of truncated division, taking the sign of d.
+func (d Decimal) Mod(o Decimal) (Decimal, error) {
+	if o.Sign() == 0 {
+		return Decimal{}, newError(DivisionByZero, "error: decimal division by zero")
+	}
+	q := Decimal{rat: new(big.Rat).Quo(d.get(), o.get())}.Round(0, RoundDown)
+	return d.Sub(q.Mul(o)), nil
+}
+
+// Pow raises d to an integral exponent. Negative exponents are rounded to scale
+// fractional digits.
+func (d Decimal) Pow(o Decimal, scale int, mode RoundingMode) (Decimal, error) {
+	if !o.IsInteger() {
+		return Decimal{}, newError(TypeMismatch, "error: decimal exponent must be an integer, got %s", o)
+	}
+	e := new(big.Int).Set(o.get().Num())
+	neg := e.Sign() < 0
+	e.Abs(e)
+	num := new(big.Int).Exp(d.get().Num(), e, nil)
+	den := new(big.Int).Exp(d.get().Denom(), e, nil)
+	if !neg {
+		return Decimal{rat: new(big.Rat).SetFrac(num, den)}, nil
+	}
+	if num.Sign() == 0 {
+		return Decimal{}, newError(DivisionByZero, "error: decimal division by zero")
+	}
+	return Decimal{rat: new(big.Rat).SetFrac(den, num)}.Round(scale, mode), nil
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{rat: new(big.Rat).Neg(d.get())}
+}
+
+func (d Decimal) Sign() int {
+	return d.get().Sign()
+}
+
+func (d Decimal) Cmp(o Decimal) int {
+	return d.get().Cmp(o.get())
+}
+
+func (d Decimal) Equal(o Decimal) bool {
+	return d.Cmp(o) == 0
+}
+
+func (d Decimal) IsInteger() bool {
+	return d.get().IsInt()
+}
+
+// Round returns d rounded to scale fractional digits using mode.
+func (d Decimal) Round(scale int, mode RoundingMode) Decimal {
+	r := d.get()
+	if scale < 0 {
+		scale = 0
+	}
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	num := new(big.Int).Mul(r.Num(), pow)
+	den := r.Denom()
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() != 0 {
+		// rem carries the sign of num; compare 2*|rem| against den to find ties.
+		half := new(big.Int).Abs(rem)
+		half.Lsh(half, 1)
+		tie := half.Cmp(den)
+		neg := num.Sign() < 0
+		inc := false
+		switch mode {
+		case RoundHalfEven:
+			inc = tie > 0 || (tie == 0 && q.Bit(0) == 1)
+		case RoundHalfUp:
+			inc = tie >= 0
+		case RoundHalfDown:
+			inc = tie > 0
+		case RoundUp:
+			inc = true
+		case RoundDown:
+			inc = false
+		case RoundCeiling:
+			inc = !neg
+		case RoundFloor:
+			inc = neg
+		}
+		if inc {
+			if neg {
+				q.Sub(q, big.NewInt(1))
+			} else {
+				q.Add(q, big.NewInt(1))
+			}
+		}
+	}
+	return Decimal{rat: new(big.Rat).SetFrac(q, pow)}
+}
+
+// Int64 returns the integral part of d and whether the conversion was exact.
+func (d Decimal) Int64() (int64, bool) {
+	t := d.Round(0, RoundDown).get().Num()
+	return t.Int64(), t.IsInt64() && d.IsInteger()
+}
+
+// Float64 returns the nearest float64 and whether it is exact.
+func (d Decimal) Float64() (float64, bool) {
+	return d.get().Float64()
+}
+
+// String prints d exactly when it has a finite decimal expansion and rounds to
+// DefaultDecimalScale digits otherwise.
+func (d Decimal) String() string {
+	r := d.get()
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	if n, exact := r.FloatPrec(); exact {
+		return r.FloatString(n)
+	}
+	return d.Round(DefaultDecimalScale, RoundHalfEven).String()
+}
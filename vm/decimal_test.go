@@ -0,0 +1,105 @@
+package smanchai
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDecimalRound(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int
+		mode  RoundingMode
+		want  string
+	}{
+		{"2.5", 0, RoundHalfEven, "2"},
+		{"3.5", 0, RoundHalfEven, "4"},
+		{"-2.5", 0, RoundHalfEven, "-2"},
+		{"-3.5", 0, RoundHalfEven, "-4"},
+		{"1.25", 1, RoundHalfEven, "1.2"},
+		{"-1.35", 1, RoundHalfEven, "-1.4"},
+		{"2.6", 0, RoundHalfEven, "3"},
+
+		{"2.5", 0, RoundHalfUp, "3"},
+		{"-2.5", 0, RoundHalfUp, "-3"},
+		{"2.4", 0, RoundHalfUp, "2"},
+		{"-1.25", 1, RoundHalfUp, "-1.3"},
+
+		{"2.9", 0, RoundDown, "2"},
+		{"-2.9", 0, RoundDown, "-2"},
+		{"-2.5", 0, RoundDown, "-2"},
+		{"1.99", 1, RoundDown, "1.9"},
+
+		{"2.1", 0, RoundCeiling, "3"},
+		{"-2.9", 0, RoundCeiling, "-2"},
+		{"-2.5", 0, RoundCeiling, "-2"},
+		{"-1.25", 1, RoundCeiling, "-1.2"},
+
+		{"2.9", 0, RoundFloor, "2"},
+		{"-2.1", 0, RoundFloor, "-3"},
+		{"-2.5", 0, RoundFloor, "-3"},
+		{"1.25", 1, RoundFloor, "1.2"},
+
+		{"-4", 0, RoundFloor, "-4"},
+	}
+	for _, tt := range tests {
+		got := MustParseDecimal(tt.in).Round(tt.scale, tt.mode)
+		if !got.Equal(MustParseDecimal(tt.want)) {
+			t.Errorf("Round(%s, %d, %s) = %s, want %s", tt.in, tt.scale, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalToIntOverflow(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  error
+	}{
+		{"42", 42, nil},
+		{"-42.000", -42, nil},
+		{"42.9", 0, TypeMismatch},
+		{"-0.5", 0, TypeMismatch},
+		{"9223372036854775807", 9223372036854775807, nil},
+		{"9223372036854775808", 0, TypeMismatch},
+		{"-99999999999999999999.5", 0, TypeMismatch},
+	}
+	for _, tt := range tests {
+		p, err := Assemble("mload " + tt.in + "\nm2i")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := p.Run(context.Background(), nil)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("m2i %s: got error %v, want %v", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("m2i %s: %v", tt.in, err)
+			continue
+		}
+		if i, _ := r.Int(); i != tt.want {
+			t.Errorf("m2i %s = %d, want %d", tt.in, i, tt.want)
+		}
+	}
+}
+
+func TestRoundingModeString(t *testing.T) {
+	tests := []struct {
+		m    RoundingMode
+		want string
+	}{
+		{RoundHalfEven, "RoundHalfEven"},
+		{RoundFloor, "RoundFloor"},
+		{7, "RoundingMode(7)"},
+		{-1, "RoundingMode(-1)"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package smanchai
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Bool returns the value of a DTypeBool.
+func (t *Data) Bool() (bool, error) {
+	if t == nil || t.Type != DTypeBool {
+		return false, mismatch(t, DTypeBool)
+	}
+	return boolValue(t.Value), nil
+}
+
+// Int returns the value of a DTypeInt or DTypeChar, or of a DTypeDouble or
+// DTypeDecimal that holds an integral value.
+func (t *Data) Int() (int64, error) {
+	if t == nil {
+		return 0, mismatch(t, DTypeInt)
+	}
+	switch t.Type {
+	case DTypeInt, DTypeChar:
+		if i, ok := intValue(t.Value); ok {
+			return i, nil
+		}
+	case DTypeDouble, DTypeDecimal:
+		r, err := Convert(t, DTypeInt)
+		if err != nil {
+			return 0, err
+		}
+		i, _ := intValue(r.Value)
+		return i, nil
+	}
+	return 0, mismatch(t, DTypeInt)
+}
+
+// Float returns the value of any numeric Data as the nearest float64. Unlike
+// Convert it accepts ints beyond 2^53 and inexact decimals, and only fails
+// when a decimal is out of the range of float64.
+func (t *Data) Float() (float64, error) {
+	if t == nil {
+		return 0, mismatch(t, DTypeDouble)
+	}
+	switch t.Type {
+	case DTypeDouble:
+		return toFloat64(t.Value), nil
+	case DTypeInt:
+		i, _ := intValue(t.Value)
+		return float64(i), nil
+	case DTypeDecimal:
+		f, _ := t.Value.(Decimal).Float64()
+		if math.IsInf(f, 0) {
+			return 0, fmt.Errorf("error: decimal %s overflows float", t.Value)
+		}
+		return f, nil
+	}
+	return 0, mismatch(t, DTypeDouble)
+}
+
+// Decimal returns the value of any numeric Data as a Decimal.
+func (t *Data) Decimal() (Decimal, error) {
+	if t == nil {
+		return Decimal{}, mismatch(t, DTypeDecimal)
+	}
+	switch t.Type {
+	case DTypeDecimal, DTypeInt, DTypeDouble:
+		return toDecimal(t)
+	}
+	return Decimal{}, mismatch(t, DTypeDecimal)
+}
+
+// Str returns the value of a DTypeString or DTypeChar. Unlike String it does
+// not format other types.
+func (t *Data) Str() (string, error) {
+	if t == nil {
+		return "", mismatch(t, DTypeString)
+	}
+	switch t.Type {
+	case DTypeString:
+		return t.Value.(string), nil
+	case DTypeChar:
+		return t.String(), nil
+	}
+	return "", mismatch(t, DTypeString)
+}
+
+// Interface converts t to plain Go values: int64, float64, bool, rune, string,
+// Decimal, []any for arrays and map[string]any for objects. nil stays nil.
+func (t *Data) Interface() any {
+	t, err := eager(t)
+	if err != nil || t == nil {
+		return nil
+	}
+	switch t.Type {
+	case DTypeInt:
+		i, _ := intValue(t.Value)
+		return i
+	case DTypeChar:
+		i, _ := intValue(t.Value)
+		return rune(i)
+	case DTypeDouble:
+		return toFloat64(t.Value)
+	case DTypeBool:
+		return boolValue(t.Value)
+	case DTypeArray:
+		arr := t.Value.(*DataObjectArray).Data
+		out := make([]any, len(arr))
+		for i, v := range arr {
+			out[i] = v.Interface()
+		}
+		return out
+	case DTypeObject:
+		omap := t.Value.(*DataObjectMap).Data
+		out := make(map[string]any, len(omap))
+		for k, v := range omap {
+			out[k] = v.Interface()
+		}
+		return out
+	}
+	return t.Value
+}
+
+// Decode stores t into the value pointed to by target, which may be any mix
+// of structs, slices, arrays, maps, pointers, interfaces and scalars. Map keys
+// are parsed back from attribute names like Reflect writes them: strings,
+// integers and encoding.TextUnmarshaler keys are supported. A string decodes
+// into a []byte as well as into an array of ints. Struct fields are matched by the same names Reflect gives them
+// (see the smanchai and json tags), falling back to a case-insensitive match.
+func (t *Data) Decode(target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("error: Decode target must be a non-nil pointer, got %T", target)
+	}
+	return decode(t, v.Elem(), "")
+}
+
+func decode(d *Data, v reflect.Value, path string) error {
+	fail := func(err error) error {
+		if path == "" {
+			return err
+		}
+		return fmt.Errorf("%w at %s", err, path)
+	}
+	d, err := eager(d)
+	if err != nil {
+		return fail(err)
+	}
+	if v.Type() == decimalType {
+		m, err := d.Decimal()
+		if err != nil {
+			return fail(err)
+		}
+		v.Set(reflect.ValueOf(m))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if d == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decode(d, v.Elem(), path)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fail(fmt.Errorf("error: cannot decode into %s", v.Type()))
+		}
+		if d == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if x := d.Interface(); x != nil {
+			v.Set(reflect.ValueOf(x))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	if d == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.Bool()
+		if err != nil {
+			return fail(err)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := d.Int()
+		if err != nil {
+			return fail(err)
+		}
+		if v.OverflowInt(i) {
+			return fail(fmt.Errorf("error: %d overflows %s", i, v.Type()))
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, err := d.Int()
+		if err != nil {
+			return fail(err)
+		}
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return fail(fmt.Errorf("error: %d overflows %s", i, v.Type()))
+		}
+		v.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, err := d.Float()
+		if err != nil {
+			return fail(err)
+		}
+		if v.OverflowFloat(f) {
+			return fail(fmt.Errorf("error: %g overflows %s", f, v.Type()))
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		s, err := d.Str()
+		if err != nil {
+			return fail(err)
+		}
+		v.SetString(s)
+	case reflect.Slice:
+		if d.Type == DTypeString && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(d.Value.(string)))
+			return nil
+		}
+		if d.Type != DTypeArray {
+			return fail(mismatch(d, DTypeArray))
+		}
+		arr := d.Value.(*DataObjectArray).Data
+		s := reflect.MakeSlice(v.Type(), len(arr), len(arr))
+		for i, e := range arr {
+			if err := decode(e, s.Index(i), joinPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		if d.Type != DTypeArray {
+			return fail(mismatch(d, DTypeArray))
+		}
+		arr := d.Value.(*DataObjectArray).Data
+		if len(arr) > v.Len() {
+			return fail(fmt.Errorf("error: array of %d items does not fit in %s", len(arr), v.Type()))
+		}
+		for i := 0; i < v.Len(); i++ {
+			var e *Data
+			if i < len(arr) {
+				e = arr[i]
+			}
+			if err := decode(e, v.Index(i), joinPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if d.Type != DTypeObject {
+			return fail(mismatch(d, DTypeObject))
+		}
+		omap := d.Value.(*DataObjectMap).Data
+		m := reflect.MakeMapWithSize(v.Type(), len(omap))
+		for k, e := range omap {
+			kv, err := decodeKey(v.Type().Key(), k)
+			if err != nil {
+				return fail(fmt.Errorf("error: cannot decode key %q into %s: %w", k, v.Type().Key(), err))
+			}
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := decode(e, ev, joinPath(path, k)); err != nil {
+				return err
+			}
+			m.SetMapIndex(kv, ev)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		if d.Type != DTypeObject {
+			return fail(mismatch(d, DTypeObject))
+		}
+		omap := d.Value.(*DataObjectMap)
+		fields := structFields(v.Type(), true)
+		for _, k := range omap.OrderedKeys() {
+			field, o := matchField(fields, k)
+			if !o {
+				continue
+			}
+			e := omap.Data[k]
+			fv, o := fieldValueAlloc(v, field.index)
+			if !o || !fv.CanSet() {
+				continue
+			}
+			if err := decode(e, fv, joinPath(path, field.name)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fail(fmt.Errorf("error: cannot decode into %s", v.Type()))
+	}
+	return nil
+}
+
+// decodeKey parses the attribute name back into a map key of type t, the
+// reverse of mapKey.
+func decodeKey(t reflect.Type, name string) (reflect.Value, error) {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		k := reflect.New(t)
+		if err := k.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name)); err != nil {
+			return reflect.Value{}, err
+		}
+		return k.Elem(), nil
+	}
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(name).Convert(t), nil
+	}
+	return mapKeyFor(t, name)
+}
+
+// matchField finds the field named name, or else the first field in
+// declaration order whose name matches it case-insensitively.
+func matchField(fields []structField, name string) (structField, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return structField{}, false
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func mismatch(d *Data, want dataType) error {
+	if d == nil {
+		return fmt.Errorf("error: expected %s, got undefined", want)
+	}
+	return fmt.Errorf("error: expected %s, got %s %s", want, d.Type, quoteData(d))
+}
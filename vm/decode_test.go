@@ -0,0 +1,171 @@
+package smanchai
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeNilInterface(t *testing.T) {
+	var x any = "old"
+	if err := (&Data{Type: DTypeString}).Decode(&x); err != nil {
+		t.Fatal(err)
+	}
+	if x != nil {
+		t.Errorf("decoded %v, want nil", x)
+	}
+}
+
+func TestDecodeLargeIntToFloat(t *testing.T) {
+	var f float64
+	if err := (&Data{Type: DTypeInt, Value: 1<<53 + 1}).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+	if f != 1<<53 {
+		t.Errorf("decoded %g, want %g", f, float64(1<<53))
+	}
+}
+
+func TestDecodeFieldCase(t *testing.T) {
+	type target struct {
+		Name string
+		NAME string
+		Age  int
+	}
+	d := &Data{Type: DTypeObject, Value: &DataObjectMap{
+		Data: map[string]*Data{
+			"name": {Type: DTypeString, Value: "a"},
+			"nAmE": {Type: DTypeString, Value: "b"},
+			"AGE":  {Type: DTypeInt, Value: 3},
+		},
+		Keys: []string{"name", "nAmE", "AGE"},
+	}}
+	for i := 0; i < 20; i++ {
+		var v target
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		// keys are matched in order, each to the first field by declaration
+		if v != (target{Name: "b", Age: 3}) {
+			t.Fatalf("decoded %+v", v)
+		}
+	}
+}
+
+type decodeLevel int
+
+func (l decodeLevel) MarshalText() ([]byte, error) {
+	return []byte([]string{"low", "high"}[l]), nil
+}
+
+func (l *decodeLevel) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "low":
+		*l = 0
+	case "high":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %q", b)
+	}
+	return nil
+}
+
+func TestDecodeBytes(t *testing.T) {
+	var b []byte
+	if err := NewString("héllo").Decode(&b); err != nil || string(b) != "héllo" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if err := NewArray(NewInt(1), NewInt(255)).Decode(&b); err != nil || !bytes.Equal(b, []byte{1, 255}) {
+		t.Errorf("got %v, %v", b, err)
+	}
+	var s []int
+	if err := NewString("ab").Decode(&s); err == nil {
+		t.Errorf("decoded a string into %v, want an error", s)
+	}
+}
+
+func TestDecodeMapKeys(t *testing.T) {
+	obj := NewObject().Put("-1", NewString("a")).Put("20", NewString("b"))
+	var ints map[int8]string
+	if err := obj.Decode(&ints); err != nil || !reflect.DeepEqual(ints, map[int8]string{-1: "a", 20: "b"}) {
+		t.Errorf("got %v, %v", ints, err)
+	}
+	var uints map[uint]string
+	if err := obj.Decode(&uints); err == nil || !strings.Contains(err.Error(), `key "-1"`) {
+		t.Errorf("got %v, %v, want a key error", uints, err)
+	}
+	if err := NewObject().Put("300", NewInt(1)).Decode(&ints); err == nil {
+		t.Errorf("decoded key 300 into int8")
+	}
+	var levels map[decodeLevel]bool
+	if err := NewObject().Put("high", NewBool(true)).Decode(&levels); err != nil || !levels[1] {
+		t.Errorf("got %v, %v", levels, err)
+	}
+	if err := NewObject().Put("mid", NewBool(true)).Decode(&levels); err == nil {
+		t.Errorf("decoded an unknown level")
+	}
+	var floats map[float64]int
+	if err := NewObject().Put("1.5", NewInt(1)).Decode(&floats); err == nil {
+		t.Errorf("decoded float keys %v", floats)
+	}
+}
+
+func TestReflectDecodeRoundTrip(t *testing.T) {
+	type item struct {
+		SKU   string `smanchai:"sku"`
+		Price Decimal
+		Qty   uint16
+	}
+	type base struct {
+		ID int64
+	}
+	type order struct {
+		base
+		Note    string `json:"note,omitempty"`
+		Items   []item
+		Lookup  map[int]*item
+		Levels  map[decodeLevel]string
+		Tags    [2]string
+		Raw     []byte
+		Ratio   float32
+		Paid    bool
+		Initial rune
+		Next    *order
+		Skipped string `smanchai:"-"`
+	}
+	in := order{
+		base:    base{ID: -9},
+		Items:   []item{{"a", MustParseDecimal("1.25"), 2}, {"b", MustParseDecimal("0.1"), 65535}},
+		Lookup:  map[int]*item{-3: {SKU: "c"}, 42: nil},
+		Levels:  map[decodeLevel]string{0: "x", 1: "y"},
+		Tags:    [2]string{"new", ""},
+		Raw:     []byte{0, 'z'},
+		Ratio:   0.5,
+		Paid:    true,
+		Initial: 'ก',
+		Next:    &order{Note: "child", Items: []item{}},
+	}
+	d, err := Reflect(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out order
+	if err := d.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	// Decimal holds a pointer, so compare the prices by value
+	for i := range in.Items {
+		if !out.Items[i].Price.Equal(in.Items[i].Price) {
+			t.Errorf("Items.%d.Price = %s, want %s", i, out.Items[i].Price, in.Items[i].Price)
+		}
+		out.Items[i].Price = in.Items[i].Price
+	}
+	if out.Lookup[-3] != nil {
+		out.Lookup[-3].Price = in.Lookup[-3].Price
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("decoded\n%+v\nwant\n%+v", out, in)
+	}
+}
@@ -0,0 +1,144 @@
+package smanchai
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var dataRefRoots = []string{
+	DRTypeVMStatic: "static",
+	DRTypeGlobal:   "global",
+	DRTypeLocal:    "local",
+}
+
+// Disassemble lists the constant pool and instructions of p, one per line:
+//
+//	#0 dataref static "user"
+//	#1 attrref "name" #0
+//	#2 string "HI"
+//	0000  1:1   getstatic #0        ; @user
+//	0002  1:1   getattr #1          ; .name
+//	0004  1:15  sload #2            ; "HI"
+//	0006  1:1   cmp_eq
+//
+// Each instruction shows its pc, the source line:column it was compiled from
+// ("-" when unknown), its mnemonic and inline operands, and after ";" the
+// constant an operand refers to. Assemble reads the output back.
+//
+// No opcode branches yet, so every instruction runs in order and there are no
+// jump targets to mark. A branch opcode should make Disassemble print a label
+// line before each target and Assemble read it back.
+func Disassemble(p *Program) string {
+	var b strings.Builder
+	for i, c := range p.consts {
+		fmt.Fprintf(&b, "#%d %s %s\n", i, typeName(c), constText(c, true))
+	}
+	for pc := 0; pc < len(p.insts); pc += 1 + operands[p.insts[pc]] {
+		op := p.insts[pc]
+		pos := "-"
+		if r, o := p.rangeAt(pc); o {
+			pos = r.String()
+		}
+		line := fmt.Sprintf("%04d  %-5s %s", pc, pos, mnemonic(op))
+		if operands[op] == 1 && pc+1 < len(p.insts) {
+			arg := p.insts[pc+1]
+			switch {
+			case op == Op_conv:
+				line += " " + dataType(arg).String()
+			case constOperand(op):
+				line += fmt.Sprintf(" #%d", arg)
+				if arg >= 0 && arg < len(p.consts) {
+					line = fmt.Sprintf("%-31s ; %s", line, constComment(p.consts[arg]))
+				}
+			default:
+				line += " " + strconv.Itoa(arg)
+			}
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// mnemonic is the opcode name without its Op_ prefix.
+func mnemonic(op int) string {
+	return strings.TrimPrefix(Opcode(op).String(), "Op_")
+}
+
+// rangeAt returns the source position pc was compiled from.
+func (p *Program) rangeAt(pc int) (Range, bool) {
+	i := len(p.ranges) - 1
+	for i >= 0 && p.ranges[i].PC > pc {
+		i--
+	}
+	if i < 0 {
+		return Range{}, false
+	}
+	return p.ranges[i].Range, true
+}
+
+// constText writes the value of a constant in the form Assemble reads. With
+// refs set, an AttrRef also names the constant it reads from.
+func constText(d *Data, refs bool) string {
+	if d == nil {
+		return "undefined"
+	}
+	switch d.Type {
+	case DTypeInt, DTypeInst:
+		i, _ := intValue(d.Value)
+		return strconv.FormatInt(i, 10)
+	case DTypeDouble:
+		return strconv.FormatFloat(toFloat64(d.Value), 'g', -1, 64)
+	case DTypeBool:
+		return strconv.FormatBool(boolValue(d.Value))
+	case DTypeChar:
+		c, _ := intValue(d.Value)
+		return strconv.QuoteRune(rune(c))
+	case DTypeString:
+		return strconv.Quote(d.Value.(string))
+	case DTypeDecimal:
+		m := d.Value.(Decimal)
+		if s := m.String(); MustParseDecimal(s).Equal(m) {
+			return s
+		}
+		return m.get().RatString()
+	case DTypeDataRef:
+		ref := d.Value.(*DataRef)
+		root := strconv.Itoa(int(ref.Root))
+		if ref.Root >= 0 && int(ref.Root) < len(dataRefRoots) {
+			root = dataRefRoots[ref.Root]
+		}
+		return root + " " + strconv.Quote(ref.Name)
+	case DTypeAttrRef:
+		ref := d.Value.(*AttrRef)
+		if !refs {
+			return strconv.Quote(ref.Name)
+		}
+		return fmt.Sprintf("%s #%d", strconv.Quote(ref.Name), ref.Root)
+	case DTypeMethodRef:
+		ref := d.Value.(*MethodRef)
+		return fmt.Sprintf("%s %d", strconv.Quote(ref.Name), ref.Argc)
+	}
+	b, err := d.MarshalJSON()
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(b)
+}
+
+func constComment(d *Data) string {
+	if d == nil {
+		return "undefined"
+	}
+	switch d.Type {
+	case DTypeDataRef:
+		return "@" + d.Value.(*DataRef).Name
+	case DTypeAttrRef:
+		return "." + d.Value.(*AttrRef).Name
+	case DTypeMethodRef:
+		ref := d.Value.(*MethodRef)
+		return fmt.Sprintf("%s/%d", ref.Name, ref.Argc)
+	}
+	return constText(d, false)
+}
@@ -0,0 +1,21 @@
+package smanchai
+
+import "testing"
+
+func TestConstTextDataRefRoot(t *testing.T) {
+	tests := []struct {
+		root DataRefType
+		want string
+	}{
+		{DRTypeVMStatic, `static "user"`},
+		{DRTypeLocal, `local "user"`},
+		{-1, `-1 "user"`},
+		{9, `9 "user"`},
+	}
+	for _, tt := range tests {
+		d := &Data{Type: DTypeDataRef, Value: &DataRef{Name: "user", Root: tt.root}}
+		if got := constText(d, true); got != tt.want {
+			t.Errorf("root %d: got %s, want %s", tt.root, got, tt.want)
+		}
+	}
+}
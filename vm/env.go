@@ -0,0 +1,90 @@
+package smanchai
+
+import (
+	"context"
+	"fmt"
+)
+
+// Static provides the value of a static. It is called with the context passed
+// to Program.Run every time a rule reads the static; an error fails the Run.
+type Static func(ctx context.Context) (*Data, error)
+
+// StaticFunc adapts a func() *Data provider, which can neither fail nor see
+// the context.
+func StaticFunc(f func() *Data) Static {
+	return func(context.Context) (*Data, error) {
+		return f(), nil
+	}
+}
+
+// StaticValue returns a Static that always provides d.
+func StaticValue(d *Data) Static {
+	return func(context.Context) (*Data, error) {
+		return d, nil
+	}
+}
+
+// Env supplies the statics a rule reads with @name. An Env may have a parent
+// whose statics it inherits and can override, so statics shared by every run,
+// like @config, live in one parent and each run gets a child holding its own,
+// like @request and @user:
+//
+//	global := smanchai.NewEnv()
+//	global.Set("config", loadConfig)
+//	global.Freeze()
+//
+//	env := global.Child()
+//	env.Set("user", currentUser)
+//	program.Run(ctx, env)
+//
+// The zero Env is empty and ready to use. An Env must not be changed while a
+// Run reads it. A frozen Env cannot be changed at all and may be shared by any
+// number of runs.
+type Env struct {
+	parent  *Env
+	statics map[string]Static
+	frozen  bool
+}
+
+func NewEnv() *Env {
+	return &Env{}
+}
+
+// Child returns an empty Env that inherits the statics of e.
+func (e *Env) Child() *Env {
+	return &Env{parent: e}
+}
+
+// Set defines the static name in e, overriding any static of that name in
+// its parents.
+func (e *Env) Set(name string, s Static) error {
+	if e.frozen {
+		return fmt.Errorf("error: cannot set static \"%s\" in a frozen env", name)
+	}
+	if e.statics == nil {
+		e.statics = map[string]Static{}
+	}
+	e.statics[name] = s
+	return nil
+}
+
+// Freeze makes e read-only and returns it. Parents are left as they are.
+func (e *Env) Freeze() *Env {
+	e.frozen = true
+	return e
+}
+
+func (e *Env) Frozen() bool {
+	return e.frozen
+}
+
+// Lookup finds the static name in e or the nearest parent defining it. A nil
+// Env has no statics.
+func (e *Env) Lookup(name string) (Static, bool) {
+	for ; e != nil; e = e.parent {
+		if s, o := e.statics[name]; o {
+			return s, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,134 @@
+package smanchai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEnvZeroValue(t *testing.T) {
+	var env Env
+	if _, o := env.Lookup("user"); o {
+		t.Error("zero Env has a static")
+	}
+	if err := env.Set("user", StaticValue(NewString("bob"))); err != nil {
+		t.Fatal(err)
+	}
+	child := env.Child()
+	child.Set("role", StaticValue(NewString("admin")))
+	r, err := compile(t, `@user + " " + @role`).Run(context.Background(), child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := r.String(); s != "bob admin" {
+		t.Errorf("got %s, want bob admin", s)
+	}
+	if _, o := env.Lookup("role"); o {
+		t.Error("a static set on a child leaked into its parent")
+	}
+}
+
+func TestStaticError(t *testing.T) {
+	cause := errors.New("db down")
+	env := NewEnv()
+	env.Set("user", func(context.Context) (*Data, error) { return nil, cause })
+	_, err := compile(t, `@user.name == "bob"`).Run(context.Background(), env)
+	if !errors.Is(err, HostFunctionFailed) || !errors.Is(err, cause) {
+		t.Fatalf("got %v, want a HostFunctionFailed wrapping %v", err, cause)
+	}
+	if !strings.Contains(err.Error(), `static "user"`) {
+		t.Errorf("%v does not name the static", err)
+	}
+}
+
+func TestStaticPanic(t *testing.T) {
+	env := NewEnv()
+	env.Set("user", func(context.Context) (*Data, error) { panic("boom") })
+	env.Set("ok", StaticValue(NewBool(true)))
+	p := compile(t, `@ok and @user.admin`)
+	for i := 0; i < 2; i++ {
+		_, err := p.Run(context.Background(), env)
+		if !errors.Is(err, HostFunctionFailed) || !strings.Contains(err.Error(), "boom") {
+			t.Fatalf("got %v, want a HostFunctionFailed", err)
+		}
+	}
+	// the run that panicked leaves nothing behind for the next one
+	r, err := compile(t, `@ok`).Run(context.Background(), env)
+	if err != nil || !r.Equal(NewBool(true)) {
+		t.Errorf("got %s, %v", r, err)
+	}
+}
+
+func TestEnvLayers(t *testing.T) {
+	root := NewEnv()
+	root.Set("user", StaticValue(NewString("ann")))
+	root.Set("role", StaticValue(NewString("guest")))
+	root.Set("site", StaticValue(NewString("main")))
+	child := root.Child()
+	child.Set("user", StaticValue(NewString("bob")))
+	grandchild := child.Child()
+	grandchild.Set("role", StaticValue(NewString("admin")))
+	p := compile(t, `@user + "/" + @role + "/" + @site`)
+	tests := []struct {
+		env  *Env
+		want string
+	}{
+		{root, "ann/guest/main"},
+		{child, "bob/guest/main"},
+		{grandchild, "bob/admin/main"},
+	}
+	for _, tt := range tests {
+		r, err := p.Run(context.Background(), tt.env)
+		if err != nil || r.String() != tt.want {
+			t.Errorf("got %s, %v, want %s", r, err, tt.want)
+		}
+	}
+	// parents are read at lookup time, not copied into children
+	root.Set("site", StaticValue(NewString("beta")))
+	if r, err := p.Run(context.Background(), grandchild); err != nil || r.String() != "bob/admin/beta" {
+		t.Errorf("got %s, %v, want bob/admin/beta", r, err)
+	}
+	if _, err := compile(t, `@missing`).Run(context.Background(), grandchild); !errors.Is(err, UnknownStatic) {
+		t.Errorf("got %v, want UnknownStatic", err)
+	}
+	if _, o := (*Env)(nil).Lookup("user"); o {
+		t.Error("nil Env has a static")
+	}
+}
+
+func TestEnvFreeze(t *testing.T) {
+	root := NewEnv()
+	root.Set("user", StaticValue(NewString("ann")))
+	if root.Freeze() != root || !root.Frozen() {
+		t.Fatal("Freeze did not freeze the Env")
+	}
+	if err := root.Set("user", StaticValue(NewString("eve"))); err == nil {
+		t.Error("changed a frozen Env")
+	}
+	if err := root.Set("other", StaticValue(nil)); err == nil {
+		t.Error("added to a frozen Env")
+	}
+	child := root.Child()
+	if child.Frozen() {
+		t.Error("the child of a frozen Env is frozen")
+	}
+	if err := child.Set("user", StaticValue(NewString("bob"))); err != nil {
+		t.Fatal(err)
+	}
+	child.Freeze()
+	if err := child.Child().Set("user", StaticValue(NewString("cat"))); err != nil {
+		t.Error(err)
+	}
+	p := compile(t, `@user`)
+	for env, want := range map[*Env]string{root: "ann", child: "bob"} {
+		if r, err := p.Run(context.Background(), env); err != nil || r.String() != want {
+			t.Errorf("got %s, %v, want %s", r, err, want)
+		}
+	}
+	parent := NewEnv()
+	parent.Child().Freeze()
+	if parent.Frozen() || parent.Set("user", StaticValue(nil)) != nil {
+		t.Error("freezing a child froze its parent")
+	}
+}
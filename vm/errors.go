@@ -0,0 +1,190 @@
+package smanchai
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorKind classifies a RuntimeError. A kind is itself an error, so
+// errors.Is(err, DivisionByZero) tells what went wrong in a Run.
+type ErrorKind int
+
+const (
+	InternalError      ErrorKind = iota // malformed program or a bug in the VM
+	UnknownStatic                       // @name missing from the Env
+	MissingAttribute                    // attribute or method not found
+	TypeMismatch                        // operand of a type the instruction cannot take
+	DivisionByZero                      //
+	IndexOutOfRange                     //
+	StackUnderflow                      // instruction found too few operands
+	LimitExceeded                       //
+	HostFunctionFailed                  // Go method or value failed or panicked
+	Canceled                            // context canceled or past its deadline
+)
+
+// Errors wrapped by a LimitExceeded RuntimeError, one per field of Limits.
+var (
+	ErrInstructionLimit = errors.New("error: instruction limit exceeded")
+	ErrStackLimit       = errors.New("error: stack depth limit exceeded")
+	ErrStringLimit      = errors.New("error: string length limit exceeded")
+	ErrCollectionLimit  = errors.New("error: collection size limit exceeded")
+)
+
+// ErrMethodNotAllowed is wrapped by the MissingAttribute RuntimeError of a call
+// to a method that exists but Options.Methods does not allow, to tell it apart
+// from a method that does not exist.
+var ErrMethodNotAllowed = errors.New("error: method not allowed")
+
+var errorKinds = []string{
+	InternalError:      "internal error",
+	UnknownStatic:      "unknown static",
+	MissingAttribute:   "missing attribute",
+	TypeMismatch:       "type mismatch",
+	DivisionByZero:     "division by zero",
+	IndexOutOfRange:    "index out of range",
+	StackUnderflow:     "stack underflow",
+	LimitExceeded:      "limit exceeded",
+	HostFunctionFailed: "host function failed",
+	Canceled:           "canceled",
+}
+
+func (k ErrorKind) String() string {
+	if k < 0 || int(k) >= len(errorKinds) {
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+	return errorKinds[k]
+}
+
+func (k ErrorKind) Error() string {
+	return k.String()
+}
+
+// RuntimeError is an error raised by Program.Run, located at the
+// sub-expression whose instruction failed.
+type RuntimeError struct {
+	Kind   ErrorKind
+	Err    error
+	Opcode Opcode // failing instruction
+	PC     int
+	Range  Range  // zero when the program carries no source positions
+	Text   string // source text of the failing sub-expression
+	source string
+}
+
+func newError(kind ErrorKind, format string, a ...any) *RuntimeError {
+	return &RuntimeError{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+// withKind classifies err as kind unless it is a RuntimeError already.
+func withKind(kind ErrorKind, err error) error {
+	if _, o := err.(*RuntimeError); o {
+		return err
+	}
+	return &RuntimeError{Kind: kind, Err: err}
+}
+
+func limitError(err error, limit int) *RuntimeError {
+	return &RuntimeError{Kind: LimitExceeded, Err: fmt.Errorf("%w (%d)", err, limit)}
+}
+
+// operandError reports operands inst cannot take, left operand first.
+func operandError(inst int, ds ...*Data) *RuntimeError {
+	types := make([]string, len(ds))
+	for i, d := range ds {
+		types[i] = typeName(d)
+	}
+	return newError(TypeMismatch, "error: %s cannot take %s", Opcode(inst), strings.Join(types, " and "))
+}
+
+// badConst reports a constant operand of the wrong type, which only a hand
+// assembled program can contain.
+func badConst(inst int, c *Data) *RuntimeError {
+	return newError(InternalError, "error: %s cannot load %s constant", Opcode(inst), typeName(c))
+}
+
+// panicError turns a Go panic inside run into a RuntimeError. Instructions
+// check their operands, so any panic but a RuntimeError is a bug in the VM or a
+// malformed Data, and fails the Run as an InternalError instead of crashing the
+// host.
+func panicError(r any) *RuntimeError {
+	switch v := r.(type) {
+	case *RuntimeError:
+		return v
+	case error:
+		return &RuntimeError{Kind: InternalError, Err: fmt.Errorf("error: %w", v)}
+	}
+	return newError(InternalError, "error: %v", r)
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Range.Line == 0 {
+		return e.Err.Error()
+	}
+	msg := fmt.Sprintf("%s at %s", e.Err, e.Range.String())
+	if e.Text != "" {
+		msg += "\n" + e.Caret()
+	}
+	return msg
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the Kind of e.
+func (e *RuntimeError) Is(target error) bool {
+	k, o := target.(ErrorKind)
+	return o && k == e.Kind
+}
+
+// Caret returns the source line of the failing sub-expression with carets
+// under it:
+//
+//	@cart.total / @cart.count > 10
+//	^^^^^^^^^^^^^^^^^^^^^^^^^
+//
+// A sub-expression spanning several lines is underlined to the end of its
+// first line.
+func (e *RuntimeError) Caret() string {
+	lines := strings.Split(e.source, "\n")
+	if e.Range.Line < 1 || e.Range.Line > len(lines) {
+		return ""
+	}
+	line := []rune(lines[e.Range.Line-1])
+	from := max(min(e.Range.Column-1, len(line)), 0)
+	to := min(from+max(e.Range.End-e.Range.Index, 1), len(line))
+	var b strings.Builder
+	b.WriteString("  " + string(line) + "\n  ")
+	for _, c := range line[:from] {
+		// keep tabs so the carets line up
+		if c == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	b.WriteString(strings.Repeat("^", max(to-from, 1)))
+	return b.String()
+}
+
+// errorAt locates err at the instruction being executed.
+func (vm *VM) errorAt(err error) error {
+	e, o := err.(*RuntimeError)
+	if !o {
+		e = &RuntimeError{Kind: InternalError, Err: err}
+	}
+	e.PC = vm.at
+	if vm.at < len(vm.insts) {
+		e.Opcode = Opcode(vm.insts[vm.at])
+	}
+	r, o := vm.rangeAt(vm.at)
+	if !o {
+		return e
+	}
+	e.Range, e.source = r, vm.source
+	if src := []rune(vm.source); r.Index < r.End && r.End <= len(src) {
+		e.Text = string(src[r.Index:r.End])
+	}
+	return e
+}
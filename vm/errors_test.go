@@ -0,0 +1,97 @@
+package smanchai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRuntimeErrorKinds(t *testing.T) {
+	env := NewEnv()
+	env.Set("none", StaticValue(nil))
+	env.Set("n", StaticValue(NewInt(5)))
+	env.Set("arr", StaticValue(NewArray(NewInt(1))))
+	tests := []struct {
+		src  string
+		want ErrorKind
+	}{
+		{`@none - 1`, TypeMismatch},
+		{`@none.name`, MissingAttribute},
+		{`decimal(@n) / 0m`, DivisionByZero},
+		{`@missing`, UnknownStatic},
+	}
+	for _, tt := range tests {
+		_, err := compile(t, tt.src).Run(context.Background(), env)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got error %v, want %s", tt.src, err, tt.want)
+		}
+	}
+	asm := []struct {
+		src  string
+		want ErrorKind
+	}{
+		{"iload 5\niload 0\nidiv", DivisionByZero},
+		{"iload 5\niload 0\nimod", DivisionByZero},
+		{"getstatic \"arr\"\ngetattr \"1\"", IndexOutOfRange},
+		{"getstatic \"arr\"\ngetattr \"-1\"", IndexOutOfRange},
+	}
+	for _, tt := range asm {
+		p, err := Assemble(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Run(context.Background(), env); !errors.Is(err, tt.want) {
+			t.Errorf("%q: got error %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestMalformedProgram(t *testing.T) {
+	tests := []struct {
+		name   string
+		insts  []int
+		consts []*Data
+		want   ErrorKind
+	}{
+		{"unknown opcode", []int{999}, nil, InternalError},
+		{"missing operand", []int{Op_sload}, nil, InternalError},
+		{"constant out of range", []int{Op_sload, 3}, []*Data{NewString("x")}, InternalError},
+		{"undefined constant", []int{Op_getattr, 0}, []*Data{nil}, InternalError},
+		{"wrong constant type", []int{Op_sload, 0}, []*Data{NewInt(1)}, InternalError},
+		{"empty stack", []int{Op_iadd}, nil, StackUnderflow},
+	}
+	for _, tt := range tests {
+		p := &Program{insts: tt.insts, consts: tt.consts}
+		_, err := p.Run(context.Background(), nil)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got error %v, want %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPanicError(t *testing.T) {
+	var arr []int
+	runtimeErr := func() (r any) {
+		defer func() { r = recover() }()
+		return arr[1]
+	}()
+	underflow := newError(StackUnderflow, "error: operand stack underflow")
+	tests := []struct {
+		in   any
+		want ErrorKind
+	}{
+		{underflow, StackUnderflow},
+		{runtimeErr, InternalError},
+		{fmt.Errorf("error: boom"), InternalError},
+		{"boom", InternalError},
+	}
+	for _, tt := range tests {
+		if got := panicError(tt.in); got.Kind != tt.want {
+			t.Errorf("panicError(%v) is %s, want %s", tt.in, got.Kind, tt.want)
+		}
+	}
+	if panicError(underflow) != underflow {
+		t.Error("panicError wrapped a RuntimeError")
+	}
+}
@@ -0,0 +1,149 @@
+package smanchai
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// structField describes how a Go struct field appears as an object attribute.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	exported  bool
+}
+
+type fieldsKey struct {
+	typ            reflect.Type
+	skipUnexported bool
+}
+
+var fieldsCache sync.Map // fieldsKey -> []structField
+
+// structFields lists the attributes of struct type t. Names come from the
+// smanchai tag, then the json tag, then the Go field name. A tag of "-" hides a
+// field, and untagged embedded structs are flattened into their parent with
+// outer fields taking precedence.
+func structFields(t reflect.Type, skipUnexported bool) []structField {
+	key := fieldsKey{typ: t, skipUnexported: skipUnexported}
+	if f, o := fieldsCache.Load(key); o {
+		return f.([]structField)
+	}
+	fields := collectFields(t, nil, skipUnexported, map[reflect.Type]bool{})
+	sort.SliceStable(fields, func(i, j int) bool {
+		return len(fields[i].index) < len(fields[j].index)
+	})
+	seen := map[string]bool{}
+	out := make([]structField, 0, len(fields))
+	for _, f := range fields {
+		if seen[f.name] {
+			continue
+		}
+		seen[f.name] = true
+		out = append(out, f)
+	}
+	f, _ := fieldsCache.LoadOrStore(key, out)
+	return f.([]structField)
+}
+
+// collectFields returns the fields of t and of its flattened embedded structs.
+func collectFields(t reflect.Type, index []int, skipUnexported bool, visiting map[reflect.Type]bool) []structField {
+	visiting[t] = true
+	defer delete(visiting, t)
+	direct := make([]structField, 0, t.NumField())
+	embedded := make([]structField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, omitEmpty, skip := parseFieldTag(field)
+		if skip {
+			continue
+		}
+		idx := append(append([]int(nil), index...), i)
+		ft := field.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct && ft != decimalType {
+			if !visiting[ft] {
+				embedded = append(embedded, collectFields(ft, idx, skipUnexported, visiting)...)
+			}
+			continue
+		}
+		if skipUnexported && !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		direct = append(direct, structField{
+			name:      name,
+			index:     idx,
+			omitEmpty: omitEmpty,
+			exported:  field.IsExported(),
+		})
+	}
+	return append(direct, embedded...)
+}
+
+func parseFieldTag(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag, o := field.Tag.Lookup("smanchai")
+	if !o {
+		tag, o = field.Tag.Lookup("json")
+	}
+	if !o {
+		return "", false, false
+	}
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return parts[0], omitEmpty, false
+}
+
+// fieldValue walks index from v, reporting false when it passes through a nil
+// embedded pointer.
+func fieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldValueAlloc walks index from v like fieldValue but allocates nil embedded
+// pointers on the way, for decoding.
+func fieldValueAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
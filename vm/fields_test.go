@@ -0,0 +1,137 @@
+package smanchai
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type tagInner struct {
+	Inner string
+	Plain int // shadowed by tagged.Plain
+}
+
+type TagDeep struct {
+	Deep string `smanchai:"deep"`
+}
+
+type TagNamed struct {
+	X int
+}
+
+type tagged struct {
+	Renamed    string `smanchai:"name"`
+	JSONName   string `json:"json_name"`
+	Both       string `smanchai:"s" json:"j"`
+	Hidden     string `smanchai:"-"`
+	JSONHidden string `json:"-"`
+	Override   string `smanchai:"-" json:"shown"`
+	OmitZero   int    `json:",omitempty"`
+	Omitted    []int  `smanchai:"omitted,omitempty"`
+	Kept       string `smanchai:"kept,omitempty"`
+	Plain      int
+	tagInner
+	*TagDeep
+	TagNamed `smanchai:"named"`
+}
+
+func TestStructTags(t *testing.T) {
+	v := tagged{
+		Renamed: "r", JSONName: "j", Both: "b", Hidden: "h", JSONHidden: "jh", Override: "o",
+		Kept: "k", Plain: 1, tagInner: tagInner{Inner: "in", Plain: 2}, TagDeep: &TagDeep{Deep: "d"},
+		TagNamed: TagNamed{X: 3},
+	}
+	want := map[string]*Data{
+		"name":      NewString("r"),
+		"json_name": NewString("j"),
+		"s":         NewString("b"),
+		"kept":      NewString("k"),
+		"Plain":     NewInt(1),
+		"Inner":     NewString("in"),
+		"deep":      NewString("d"),
+		"named":     NewObject().Put("X", NewInt(3)),
+	}
+	d, err := Reflect(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := d.Value.(*DataObjectMap).Data
+	if keys, wantKeys := sortedKeys(got), sortedKeys(want); !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("attributes %v, want %v", keys, wantKeys)
+	}
+	l, err := Lazy(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, w := range want {
+		if !got[name].Equal(w) {
+			t.Errorf("%s = %s, want %s", name, got[name], w)
+		}
+		if a, err := l.Value.(*DataReflect).Attr(name); err != nil || !w.Equal(materialize(t, a)) {
+			t.Errorf("lazy %s = %s, %v, want %s", name, a, err, w)
+		}
+	}
+	for _, name := range []string{"Renamed", "JSONName", "Both", "j", "Hidden", "JSONHidden", "Override", "shown", "OmitZero", "omitted", "tagInner", "TagDeep", "TagNamed"} {
+		if a, err := l.Value.(*DataReflect).Attr(name); a != nil || err != nil {
+			t.Errorf("lazy %s = %s, %v, want undefined", name, a, err)
+		}
+	}
+}
+
+func TestStructTagsOmitEmpty(t *testing.T) {
+	v := tagged{OmitZero: 5, Omitted: []int{1}}
+	d, err := Reflect(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, w := range map[string]*Data{"OmitZero": NewInt(5), "omitted": NewArray(NewInt(1))} {
+		if a := d.Get(name); !a.Equal(w) {
+			t.Errorf("%s = %s, want %s", name, a, w)
+		}
+	}
+	if _, o := d.Value.(*DataObjectMap).Data["kept"]; o {
+		t.Errorf("empty kept was not omitted")
+	}
+	// a nil embedded pointer hides its fields
+	if _, o := d.Value.(*DataObjectMap).Data["deep"]; o {
+		t.Errorf("deep is present behind a nil embedded pointer")
+	}
+}
+
+func TestStructTagsDecode(t *testing.T) {
+	obj := NewObject().
+		Put("name", NewString("r")).
+		Put("j", NewString("ignored")).
+		Put("s", NewString("b")).
+		Put("Hidden", NewString("h")).
+		Put("shown", NewString("o")).
+		Put("Inner", NewString("in")).
+		Put("deep", NewString("d")).
+		Put("named", NewObject().Put("X", NewInt(3)))
+	var v tagged
+	if err := obj.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	want := tagged{Renamed: "r", Both: "b", tagInner: tagInner{Inner: "in"}, TagDeep: &TagDeep{Deep: "d"}, TagNamed: TagNamed{X: 3}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("decoded %+v, want %+v", v, want)
+	}
+}
+
+func sortedKeys(m map[string]*Data) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func materialize(t *testing.T, d *Data) *Data {
+	t.Helper()
+	d, err := eager(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
@@ -0,0 +1,334 @@
+package smanchai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DataFromJSON builds a Data tree from a plain JSON document. Integers become
+// DTypeInt (or DTypeDecimal when they overflow int64), other numbers become
+// DTypeDouble, null becomes nil and object key order is kept in
+// DataObjectMap.Keys.
+func DataFromJSON(b []byte) (*Data, error) {
+	return DataFromReader(bytes.NewReader(b))
+}
+
+// DataFromReader is DataFromJSON reading a single document from r.
+func DataFromReader(r io.Reader) (*Data, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	d, err := readJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("error: unexpected data after JSON document")
+	}
+	return d, nil
+}
+
+func readJSON(dec *json.Decoder) (*Data, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &Data{Type: DTypeBool, Value: t}, nil
+	case string:
+		return &Data{Type: DTypeString, Value: t}, nil
+	case json.Number:
+		return jsonNumber(t)
+	case json.Delim:
+		switch t {
+		case '[':
+			oarr := []*Data{}
+			for dec.More() {
+				v, err := readJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				oarr = append(oarr, v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return &Data{Type: DTypeArray, Value: &DataObjectArray{Data: oarr}}, nil
+		case '{':
+			omap := &DataObjectMap{Data: map[string]*Data{}}
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := k.(string)
+				v, err := readJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				if _, o := omap.Data[key]; !o {
+					omap.Keys = append(omap.Keys, key)
+				}
+				omap.Data[key] = v
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return &Data{Type: DTypeObject, Value: omap}, nil
+		}
+	}
+	return nil, fmt.Errorf("error: unexpected JSON token %v", tok)
+}
+
+func jsonNumber(n json.Number) (*Data, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 0); err == nil {
+			return &Data{Type: DTypeInt, Value: int(i)}, nil
+		}
+		m, err := ParseDecimal(s)
+		if err != nil {
+			return nil, err
+		}
+		return &Data{Type: DTypeDecimal, Value: m}, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, err
+	}
+	return &Data{Type: DTypeDouble, Value: f}, nil
+}
+
+// MarshalJSON encodes t in a typed form that UnmarshalJSON reads back without
+// loss: every value is {"t": <type>, "v": <value>}, with decimals as strings,
+// chars as one-character strings, infinite and NaN floats as the strings
+// "+Inf", "-Inf" and "NaN", and object keys in OrderedKeys order. A nil *Data
+// encodes as null.
+func (t *Data) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeTypedJSON(&buf, t); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTypedJSON(buf *bytes.Buffer, t *Data) error {
+	t, err := eager(t)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	if int(t.Type) >= len(dataTypes) {
+		return fmt.Errorf("error: cannot encode data type %d", t.Type)
+	}
+	buf.WriteString(`{"t":`)
+	writeJSONString(buf, t.Type.String())
+	buf.WriteString(`,"v":`)
+	switch t.Type {
+	case DTypeArray:
+		buf.WriteByte('[')
+		for i, v := range t.Value.(*DataObjectArray).Data {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeTypedJSON(buf, v); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case DTypeObject:
+		omap := t.Value.(*DataObjectMap)
+		buf.WriteByte('{')
+		for i, k := range omap.OrderedKeys() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, k)
+			buf.WriteByte(':')
+			if err := writeTypedJSON(buf, omap.Data[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case DTypeInt, DTypeInst:
+		i, _ := intValue(t.Value)
+		buf.WriteString(strconv.FormatInt(i, 10))
+	case DTypeDouble:
+		f := toFloat64(t.Value)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			// JSON has no number for these, as 1 / 0 gives
+			writeJSONString(buf, strconv.FormatFloat(f, 'g', -1, 64))
+			break
+		}
+		b, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	case DTypeBool:
+		buf.WriteString(strconv.FormatBool(boolValue(t.Value)))
+	case DTypeChar, DTypeString:
+		writeJSONString(buf, t.String())
+	case DTypeDecimal:
+		writeJSONString(buf, t.Value.(Decimal).String())
+	default:
+		b, err := json.Marshal(t.Value)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+type typedJSON struct {
+	T string          `json:"t"`
+	V json.RawMessage `json:"v"`
+}
+
+// UnmarshalJSON reads the typed form written by MarshalJSON.
+func (t *Data) UnmarshalJSON(b []byte) error {
+	d, err := readTypedJSON(b)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		*t = Data{}
+		return nil
+	}
+	*t = *d
+	return nil
+}
+
+func readTypedJSON(b []byte) (*Data, error) {
+	if bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+		return nil, nil
+	}
+	var raw typedJSON
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	typ := -1
+	for i, name := range dataTypes {
+		if name == raw.T && i != DTypeReflect {
+			typ = i
+			break
+		}
+	}
+	if typ < 0 {
+		return nil, fmt.Errorf("error: unknown data type \"%s\"", raw.T)
+	}
+	d := &Data{Type: dataType(typ)}
+	var err error
+	switch typ {
+	case DTypeArray:
+		var items []json.RawMessage
+		if err = json.Unmarshal(raw.V, &items); err != nil {
+			return nil, err
+		}
+		oarr := make([]*Data, len(items))
+		for i, item := range items {
+			if oarr[i], err = readTypedJSON(item); err != nil {
+				return nil, err
+			}
+		}
+		d.Value = &DataObjectArray{Data: oarr}
+	case DTypeObject:
+		omap := &DataObjectMap{Data: map[string]*Data{}}
+		dec := json.NewDecoder(bytes.NewReader(raw.V))
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil, fmt.Errorf("error: expected object value for \"object\"")
+		}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			var item json.RawMessage
+			if err := dec.Decode(&item); err != nil {
+				return nil, err
+			}
+			v, err := readTypedJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			key := k.(string)
+			if _, o := omap.Data[key]; !o {
+				omap.Keys = append(omap.Keys, key)
+			}
+			omap.Data[key] = v
+		}
+		d.Value = omap
+	case DTypeInt, DTypeInst:
+		var i int
+		err = json.Unmarshal(raw.V, &i)
+		d.Value = i
+	case DTypeDouble:
+		var f float64
+		if bytes.HasPrefix(raw.V, []byte(`"`)) {
+			var s string
+			if err = json.Unmarshal(raw.V, &s); err == nil {
+				f, err = strconv.ParseFloat(s, 64)
+				if err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+					err = fmt.Errorf("finite float \"%s\" must be a number", s)
+				}
+			}
+		} else {
+			err = json.Unmarshal(raw.V, &f)
+		}
+		d.Value = f
+	case DTypeBool:
+		var v bool
+		err = json.Unmarshal(raw.V, &v)
+		d.Value = v
+	case DTypeString:
+		var s string
+		err = json.Unmarshal(raw.V, &s)
+		d.Value = s
+	case DTypeChar:
+		var s string
+		if err = json.Unmarshal(raw.V, &s); err == nil {
+			var c *Data
+			if c, err = Convert(&Data{Type: DTypeString, Value: s}, DTypeChar); err == nil {
+				d.Value = c.Value
+			}
+		}
+	case DTypeDecimal:
+		var s string
+		if err = json.Unmarshal(raw.V, &s); err == nil {
+			d.Value, err = ParseDecimal(s)
+		}
+	case DTypeDataRef:
+		v := &DataRef{}
+		err = json.Unmarshal(raw.V, v)
+		d.Value = v
+	case DTypeAttrRef:
+		v := &AttrRef{}
+		err = json.Unmarshal(raw.V, v)
+		d.Value = v
+	case DTypeMethodRef:
+		v := &MethodRef{}
+		err = json.Unmarshal(raw.V, v)
+		d.Value = v
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid %s value: %w", raw.T, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,133 @@
+package smanchai
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestTypedJSONRoundTrip(t *testing.T) {
+	obj := NewObject()
+	obj.Set("z", NewInt(1))
+	obj.Set("a", NewArray(NewString("x"), nil, NewDecimal(MustParseDecimal("-0.125"))))
+	inner := NewObject()
+	inner.Set("deep", NewArray(NewArray(NewChar('é'))))
+	obj.Set("m", inner)
+	tests := []*Data{
+		nil,
+		NewInt(-42),
+		{Type: DTypeInst, Value: 7},
+		NewFloat(1.5),
+		NewFloat(-0.1),
+		NewFloat(math.Inf(1)),
+		NewFloat(math.Inf(-1)),
+		NewBool(true),
+		NewChar('x'),
+		NewChar('ก'),
+		NewString("quote \" and\nnewline"),
+		NewDecimal(MustParseDecimal("12345678901234567890.000000001")),
+		{Type: DTypeDataRef, Value: &DataRef{Name: "user", Root: DRTypeVMStatic}},
+		{Type: DTypeAttrRef, Value: &AttrRef{Name: "name", Root: 3}},
+		{Type: DTypeMethodRef, Value: &MethodRef{Name: "HasRole", Argc: 1}},
+		NewArray(),
+		obj,
+	}
+	for _, d := range tests {
+		b, err := json.Marshal(d)
+		if err != nil {
+			t.Errorf("%s: %v", d, err)
+			continue
+		}
+		got, err := readTypedJSON(b)
+		if err != nil {
+			t.Errorf("%s: %v", b, err)
+			continue
+		}
+		if (got == nil) != (d == nil) || (d != nil && (got.Type != d.Type || !got.Equal(d))) {
+			t.Errorf("%s read back as %s", b, got)
+		}
+	}
+}
+
+func TestTypedJSONNaN(t *testing.T) {
+	b, err := json.Marshal(NewFloat(math.NaN()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"t":"float","v":"NaN"}` {
+		t.Errorf("got %s", b)
+	}
+	d, err := readTypedJSON(b)
+	if err != nil || d.Type != DTypeDouble || !math.IsNaN(d.Value.(float64)) {
+		t.Errorf("read back as %v, %v", d, err)
+	}
+}
+
+func TestTypedJSONInfiniteResult(t *testing.T) {
+	r, err := compile(t, `1 / 0`).Run(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"t":"float","v":"+Inf"}` {
+		t.Errorf("got %s", b)
+	}
+}
+
+func TestTypedJSONMalformed(t *testing.T) {
+	tests := []string{
+		``,
+		`{"t":"int"`,
+		`[1]`,
+		`{"t":"nope","v":1}`,
+		`{"t":"int","v":"1"}`,
+		`{"t":"int","v":1.5}`,
+		`{"t":"float","v":"1.5"}`,
+		`{"t":"float","v":"Infinity and beyond"}`,
+		`{"t":"bool","v":1}`,
+		`{"t":"char","v":"ab"}`,
+		`{"t":"char","v":""}`,
+		`{"t":"decimal","v":"12abc"}`,
+		`{"t":"decimal","v":12}`,
+		`{"t":"array","v":{}}`,
+		`{"t":"array","v":[{"t":"int","v":"x"}]}`,
+		`{"t":"object","v":[]}`,
+		`{"t":"object","v":{"k":{"t":"bool","v":"no"}}}`,
+	}
+	for _, src := range tests {
+		if d, err := readTypedJSON([]byte(src)); err == nil {
+			t.Errorf("%s: read as %s, want an error", src, d)
+		}
+	}
+}
+
+func TestDataFromJSON(t *testing.T) {
+	d, err := DataFromJSON([]byte(`{"b": 1, "a": [1.5, null, "s", true], "big": 123456789012345678901234567890}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := d.Value.(*DataObjectMap).OrderedKeys(); len(keys) != 3 || keys[0] != "b" || keys[1] != "a" {
+		t.Errorf("keys = %v, want document order", keys)
+	}
+	if b := d.Get("b"); b.Type != DTypeInt {
+		t.Errorf("b is %s, want int", typeName(b))
+	}
+	if f := d.Get("a.0"); f.Type != DTypeDouble {
+		t.Errorf("a.0 is %s, want float", typeName(f))
+	}
+	if n := d.Get("a.1"); n != nil {
+		t.Errorf("a.1 = %s, want undefined", n)
+	}
+	if big := d.Get("big"); big.Type != DTypeDecimal {
+		t.Errorf("big is %s, want decimal", typeName(big))
+	}
+	for _, src := range []string{`{"a": }`, `[1, 2`, `1 2`} {
+		if _, err := DataFromJSON([]byte(src)); err == nil {
+			t.Errorf("%s: want an error", src)
+		}
+	}
+}
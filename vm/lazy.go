@@ -0,0 +1,188 @@
+package smanchai
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// DataReflect is the Value of a DTypeReflect. It wraps a Go struct, map, slice
+// or array and converts attributes only when Op_getattr reads them, so the
+// cost of a lookup depends on the path length rather than the object size.
+type DataReflect struct {
+	value     reflect.Value
+	reflector *Reflector
+}
+
+func Lazy(data any) (*Data, error) {
+	return (&Reflector{}).Lazy(data)
+}
+
+// Lazy wraps data in a DTypeReflect that is converted on demand using the
+// options of r. Scalars are converted immediately.
+func (r *Reflector) Lazy(data any) (*Data, error) {
+	return r.lazy(addressable(reflect.ValueOf(data)))
+}
+
+func (r *Reflector) lazy(v reflect.Value) (*Data, error) {
+	for {
+		if !v.IsValid() {
+			return nil, nil
+		}
+		if d, o, err := r.custom(v); o {
+			return d, err
+		}
+		if v.Kind() != reflect.Pointer && v.Kind() != reflect.Interface {
+			break
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == decimalType {
+			break
+		}
+		return &Data{Type: DTypeReflect, Value: &DataReflect{value: v, reflector: r}}, nil
+	case reflect.Map, reflect.Array:
+		return &Data{Type: DTypeReflect, Value: &DataReflect{value: v, reflector: r}}, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && !r.BytesAsArray {
+			break
+		}
+		return &Data{Type: DTypeReflect, Value: &DataReflect{value: v, reflector: r}}, nil
+	}
+	return r.reflectValue(v)
+}
+
+func (r *Reflector) reflectValue(v reflect.Value) (*Data, error) {
+	w := &reflectWalk{
+		Reflector: r,
+		seen:      map[reflectVisit]bool{},
+	}
+	return w.toData(v, "")
+}
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	stringerType        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+var fieldTables sync.Map // fieldsKey -> map[string]structField
+
+func fieldTable(t reflect.Type, skipUnexported bool) map[string]structField {
+	key := fieldsKey{typ: t, skipUnexported: skipUnexported}
+	if f, o := fieldTables.Load(key); o {
+		return f.(map[string]structField)
+	}
+	fields := structFields(t, skipUnexported)
+	table := make(map[string]structField, len(fields))
+	for _, f := range fields {
+		table[f.name] = f
+	}
+	f, _ := fieldTables.LoadOrStore(key, table)
+	return f.(map[string]structField)
+}
+
+// Attr resolves a single attribute. Missing struct fields and map keys yield
+// nil, like attributes missing from a DataObjectMap, and so do empty omitempty
+// fields, which Reflect leaves out.
+func (t *DataReflect) Attr(name string) (*Data, error) {
+	v := t.value
+	switch v.Kind() {
+	case reflect.Struct:
+		field, o := fieldTable(v.Type(), t.reflector.SkipUnexported)[name]
+		if !o {
+			return nil, nil
+		}
+		value, o := fieldValue(v, field.index)
+		if !o || (field.omitEmpty && isEmptyValue(value)) {
+			return nil, nil
+		}
+		return t.reflector.lazy(value)
+	case reflect.Map:
+		key, err := mapKeyFor(v.Type().Key(), name)
+		if err != nil {
+			// keys named through TextMarshaler or Stringer; compare each one
+			iter := v.MapRange()
+			for iter.Next() {
+				if k, err := mapKey(iter.Key()); err == nil && k == name {
+					return t.reflector.lazy(iter.Value())
+				}
+			}
+			return nil, nil
+		}
+		value := v.MapIndex(key)
+		if !value.IsValid() {
+			return nil, nil
+		}
+		return t.reflector.lazy(value)
+	case reflect.Slice, reflect.Array:
+		i, err := strconv.Atoi(name)
+		if err != nil || i < 0 || i >= v.Len() {
+			return nil, newError(IndexOutOfRange, "error: index \"%s\" out of range [0:%d]", name, v.Len())
+		}
+		return t.reflector.lazy(v.Index(i))
+	}
+	return nil, newError(TypeMismatch, "error: cannot read attribute \"%s\" of %s", name, v.Type())
+}
+
+// Len returns the number of fields, entries or items of the wrapped value.
+func (t *DataReflect) Len() int {
+	if t.value.Kind() == reflect.Struct {
+		return len(structFields(t.value.Type(), t.reflector.SkipUnexported))
+	}
+	return t.value.Len()
+}
+
+// Materialize converts the whole wrapped value eagerly, as Reflect would.
+func (t *DataReflect) Materialize() (*Data, error) {
+	return t.reflector.reflectValue(t.value)
+}
+
+func (t *DataReflect) String() string {
+	d, err := t.Materialize()
+	if err != nil {
+		return fmt.Sprintf("<%s>", t.value.Type())
+	}
+	return d.String()
+}
+
+// eager returns d with a DTypeReflect replaced by its fully converted value.
+func eager(d *Data) (*Data, error) {
+	if d == nil || d.Type != DTypeReflect {
+		return d, nil
+	}
+	return d.Value.(*DataReflect).Materialize()
+}
+
+func mapKeyFor(t reflect.Type, name string) (reflect.Value, error) {
+	if t.Implements(textMarshalerType) || t.Implements(stringerType) {
+		return reflect.Value{}, fmt.Errorf("error: cannot look up %s keys by name", t)
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(name).Convert(t), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(name, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(i).Convert(t), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(name, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(u).Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("error: cannot look up %s keys by name", t)
+}
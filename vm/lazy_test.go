@@ -0,0 +1,109 @@
+package smanchai
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type lazyAccount struct {
+	Owner   countingValuer
+	Balance countingValuer
+	History []countingValuer
+	Limits  map[string]countingValuer
+}
+
+func TestLazyReadsOnAccess(t *testing.T) {
+	var owner, balance, history, limits int
+	account := &lazyAccount{
+		Owner:   countingValuer{&owner},
+		Balance: countingValuer{&balance},
+		History: []countingValuer{{&history}, {&history}, {&history}},
+		Limits:  map[string]countingValuer{"daily": {&limits}, "monthly": {&limits}},
+	}
+	d, err := Lazy(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner+balance+history+limits != 0 {
+		t.Fatalf("Lazy read fields before any access")
+	}
+	env := NewEnv()
+	env.Set("account", StaticValue(d))
+	tests := []struct {
+		src                             string
+		owner, balance, history, limits int
+	}{
+		{`@account.Owner == "expensive"`, 1, 0, 0, 0},
+		{`@account.Limits.daily == @account.Owner`, 2, 0, 0, 1},
+		{`@account.Balance`, 2, 1, 0, 1},
+	}
+	for _, tt := range tests {
+		if _, err := compile(t, tt.src).Run(context.Background(), env); err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+		if owner != tt.owner || balance != tt.balance || history != tt.history || limits != tt.limits {
+			t.Errorf("%s: read owner %d, balance %d, history %d, limits %d times, want %d, %d, %d, %d",
+				tt.src, owner, balance, history, limits, tt.owner, tt.balance, tt.history, tt.limits)
+		}
+	}
+}
+
+type lazyShared struct {
+	Name  string `smanchai:"name"`
+	Level int    `json:"level,omitempty"`
+	lazyEmbedded
+}
+
+type lazyEmbedded struct {
+	Team string
+}
+
+// TestLazyConcurrentFieldCache runs the first lookups of a struct type from
+// many goroutines at once; run it with -race.
+func TestLazyConcurrentFieldCache(t *testing.T) {
+	p := compile(t, `@u.name + string(@u.level) + @u.Team`)
+	var start, wg sync.WaitGroup
+	start.Add(1)
+	errs := make(chan error, 16)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			start.Wait()
+			for i := 0; i < 20; i++ {
+				v := lazyShared{Name: "n", Level: g + 1, lazyEmbedded: lazyEmbedded{Team: "t"}}
+				var d *Data
+				var err error
+				if i%2 == 0 {
+					d, err = Lazy(v)
+				} else {
+					d, err = (&Reflector{SkipUnexported: true}).Lazy(&v)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				env := NewEnv()
+				env.Set("u", StaticValue(d))
+				r, err := p.Run(context.Background(), env)
+				if want := fmt.Sprintf("n%dt", g+1); err != nil || r.String() != want {
+					errs <- fmt.Errorf("goroutine %d: got %s, %v, want %s", g, r, err, want)
+					return
+				}
+				var back lazyShared
+				if err := d.Decode(&back); err != nil || back != v {
+					errs <- fmt.Errorf("goroutine %d: decoded %+v, %v", g, back, err)
+					return
+				}
+			}
+		}(g)
+	}
+	start.Done()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"unicode"
 )
 
+// Range locates a token or sub-expression in the source. Line and Column are
+// 1-based and point at its first character; Index and End are rune offsets of
+// its first character and just past its last.
 type Range struct {
 	Line   int
 	Column int
 	Index  int
+	End    int
 }
 
 func (r *Range) String() string {
@@ -22,7 +26,7 @@ type Lexer struct {
 	column int
 	index  int
 	reader *Reader
-	Dg     bool
+	Trace  func(r Range, token Token, str string) // called with every token, see TextTracer.OnToken
 	buf    struct {
 		r     Range
 		token Token
@@ -35,7 +39,6 @@ func NewLexer(reader io.Reader) *Lexer {
 		line:   1,
 		column: 0,
 		index:  0,
-		Dg:     false,
 		reader: NewReader(bufio.NewReader(reader)),
 	}
 }
@@ -78,12 +81,14 @@ func (l *Lexer) back() {
 }
 
 func (l *Lexer) Lex() (Range, Token, string) {
-	r, token, str := l.lex()
+	start := l.index
+	_, token, str := l.lex()
+	r := l.span(start, l.index)
 	l.buf.r = r
 	l.buf.token = token
 	l.buf.str = str
-	if l.Dg {
-		fmt.Printf("Lx: \t%s\t%s\t%s\n", r.String(), token.String(), str)
+	if l.Trace != nil {
+		l.Trace(r, token, str)
 	}
 	return r, token, str
 }
@@ -140,18 +145,18 @@ func (l *Lexer) lex() (Range, Token, string) {
 	if l.isKeyword(c, "!=") {
 		return l.save(), EQUALITY_OPERATOR, "!="
 	}
-	if c == '>' {
-		return l.save(), COMPARISON_OPERATOR, ">"
-	}
-	if c == '<' {
-		return l.save(), COMPARISON_OPERATOR, "<"
-	}
 	if l.isKeyword(c, ">=") {
 		return l.save(), COMPARISON_OPERATOR, ">="
 	}
 	if l.isKeyword(c, "<=") {
 		return l.save(), COMPARISON_OPERATOR, "<="
 	}
+	if c == '>' {
+		return l.save(), COMPARISON_OPERATOR, ">"
+	}
+	if c == '<' {
+		return l.save(), COMPARISON_OPERATOR, "<"
+	}
 	if l.isKeyword(c, "and") {
 		return l.save(), CONJUNCTION, "and"
 	}
@@ -222,7 +227,6 @@ func (l *Lexer) lexWhiteSpace() (Range, Token, string) {
 func (l *Lexer) lexNumber() (Range, Token, string) {
 	result := ""
 	float := false
-	zc := 0
 	r := l.save()
 	for {
 		c, _, err := l.next()
@@ -233,10 +237,7 @@ func (l *Lexer) lexNumber() (Range, Token, string) {
 			panic(err)
 		}
 		if unicode.IsDigit(c) {
-			if !float && c == '0' {
-				zc++
-			}
-			if !float && zc > 1 {
+			if c == '0' && result == "0" {
 				panic("WTF Number begin with double 0??")
 			}
 			result += string(c)
@@ -246,6 +247,8 @@ func (l *Lexer) lexNumber() (Range, Token, string) {
 			}
 			float = true
 			result += string(c)
+		} else if c == 'm' {
+			return r, DECIMAL, result
 		} else {
 			l.back()
 			return r, NUMBER, result
@@ -306,3 +309,14 @@ func (l *Lexer) isKeyword(c rune, base string) bool {
 	}
 	return true
 }
+
+// span returns the Range of the runes from start to end.
+func (l *Lexer) span(start int, end int) Range {
+	line, column := l.reader.position(start)
+	return Range{Line: line, Column: column, Index: start, End: end}
+}
+
+// Source returns the text read so far.
+func (l *Lexer) Source() string {
+	return string(l.reader.source)
+}
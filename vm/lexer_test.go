@@ -0,0 +1,72 @@
+package smanchai
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLexPosition(t *testing.T) {
+	l := NewLexer(strings.NewReader("@a +\n  @bb\n\n\"x\ty\""))
+	want := []struct {
+		str       string
+		line, col int
+	}{
+		{"@", 1, 1}, {"a", 1, 2}, {" ", 1, 3}, {"+", 1, 4}, {"\n  ", 1, 5},
+		{"@", 2, 3}, {"bb", 2, 4}, {"\n\n", 2, 6}, {"x\ty", 4, 1},
+	}
+	for _, w := range want {
+		r, _, str := l.Lex()
+		if str != w.str || r.Line != w.line || r.Column != w.col {
+			t.Errorf("got %q at %s, want %q at %d:%d", str, r.String(), w.str, w.line, w.col)
+		}
+	}
+}
+
+func benchmarkLex(b *testing.B, n int) {
+	src := strings.Repeat("@cart.total * 2 +\n", n/6) + "1"
+	for i := 0; i < b.N; i++ {
+		l := NewLexer(strings.NewReader(src))
+		for _, token, _ := l.Lex(); token != EOF; _, token, _ = l.Lex() {
+		}
+	}
+}
+
+func BenchmarkLex5k(b *testing.B)  { benchmarkLex(b, 5000) }
+func BenchmarkLex20k(b *testing.B) { benchmarkLex(b, 20000) }
+
+func TestLexNumber(t *testing.T) {
+	tests := []struct {
+		src   string
+		token Token
+		str   string
+	}{
+		{"0", NUMBER, "0"},
+		{"100", NUMBER, "100"},
+		{"100m", DECIMAL, "100"},
+		{"1000.5", NUMBER, "1000.5"},
+		{"10.0m", DECIMAL, "10.0"},
+		{"0.001", NUMBER, "0.001"},
+		{"05", NUMBER, "05"},
+		{"200 ", NUMBER, "200"},
+	}
+	for _, tt := range tests {
+		if _, token, str := NewLexer(strings.NewReader(tt.src)).Lex(); token != tt.token || str != tt.str {
+			t.Errorf("%s: got %s %q, want %s %q", tt.src, token, str, tt.token, tt.str)
+		}
+	}
+	for _, src := range []string{"00", "007", "1.2.3"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: lexed without a panic", src)
+				}
+			}()
+			NewLexer(strings.NewReader(src)).Lex()
+		}()
+	}
+	r, err := compile(t, `100m * 2 + decimal(1000)`).Run(context.Background(), nil)
+	if err != nil || !r.Equal(NewDecimal(MustParseDecimal("1200"))) {
+		t.Errorf("got %s, %v, want 1200", r, err)
+	}
+}
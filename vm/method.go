@@ -0,0 +1,123 @@
+package smanchai
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MethodRef is the Value of a DTypeMethodRef constant, naming the method
+// Op_invoke calls and how many arguments it pops.
+type MethodRef struct {
+	Name string
+	Argc int
+}
+
+// MethodFilter reports whether rules may call method name on a value of type
+// typ. A nil Options.Methods allows no method at all, so rules written by
+// untrusted authors can only call what AllowMethods lists. A call the filter
+// denies fails with ErrMethodNotAllowed.
+//
+// Methods can only be called on Lazy values. Reflect converts a Go value into
+// plain objects and arrays up front, so nothing it returns has methods.
+type MethodFilter func(typ reflect.Type, name string) bool
+
+// AllowAllMethods lets rules call every exported method of every value they
+// can reach. Only use it for trusted rules.
+func AllowAllMethods(reflect.Type, string) bool {
+	return true
+}
+
+// AllowMethods returns a MethodFilter that only allows the listed methods.
+// Entries are either a bare method name ("HasRole") or qualified by the
+// receiver type name ("User.HasRole", "*User.HasRole" is treated the same).
+func AllowMethods(names ...string) MethodFilter {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[strings.TrimPrefix(name, "*")] = true
+	}
+	return func(typ reflect.Type, name string) bool {
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		return allowed[name] || allowed[typ.Name()+"."+name]
+	}
+}
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// invokeMethod calls the exported Go method ref.Name on the value wrapped by
+// recv. Arguments are decoded into the parameter types; a leading
+// context.Context parameter receives the context of the run and a trailing
+// error result is returned as a runtime error.
+func (vm *VM) invokeMethod(recv *Data, ref *MethodRef, args []*Data) (result *Data, err error) {
+	if recv == nil {
+		return nil, newError(TypeMismatch, "error: cannot call method \"%s\" of undefined", ref.Name)
+	}
+	if recv.Type != DTypeReflect {
+		return nil, newError(TypeMismatch, "error: cannot call method \"%s\" on %s, only Lazy values have methods", ref.Name, recv.Type)
+	}
+	r := recv.Value.(*DataReflect)
+	v := r.value
+	if !v.CanInterface() {
+		return nil, newError(MissingAttribute, "error: cannot call method \"%s\" on a value read from an unexported field", ref.Name)
+	}
+	method := v.MethodByName(ref.Name)
+	if !method.IsValid() && v.CanAddr() {
+		method = v.Addr().MethodByName(ref.Name)
+	}
+	if !method.IsValid() {
+		return nil, newError(MissingAttribute, "error: %s has no method \"%s\"", v.Type(), ref.Name)
+	}
+	if vm.options.Methods == nil || !vm.options.Methods(v.Type(), ref.Name) {
+		return nil, &RuntimeError{Kind: MissingAttribute, Err: fmt.Errorf("%w: \"%s\" of %s, see Options.Methods", ErrMethodNotAllowed, ref.Name, v.Type())}
+	}
+	mt := method.Type()
+	in := make([]reflect.Value, 0, len(args)+1)
+	if mt.NumIn() > 0 && mt.In(0) == contextType {
+		in = append(in, reflect.ValueOf(vm.ctx))
+	}
+	skip := len(in)
+	if n := mt.NumIn() - skip; (!mt.IsVariadic() && len(args) != n) || (mt.IsVariadic() && len(args) < n-1) {
+		return nil, newError(TypeMismatch, "error: method \"%s\" expects %d arguments, got %d", ref.Name, n, len(args))
+	}
+	for i, arg := range args {
+		var pt reflect.Type
+		if mt.IsVariadic() && skip+i >= mt.NumIn()-1 {
+			pt = mt.In(mt.NumIn() - 1).Elem()
+		} else {
+			pt = mt.In(skip + i)
+		}
+		in = append(in, reflect.New(pt).Elem())
+		if err := decode(arg, in[skip+i], ""); err != nil {
+			return nil, newError(TypeMismatch, "error: argument %d of method \"%s\": %w", i+1, ref.Name, err)
+		}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			result, err = nil, newError(HostFunctionFailed, "error: method \"%s\" panicked: %v", ref.Name, p)
+		}
+	}()
+	out := method.Call(in)
+	if n := len(out); n > 0 && mt.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return nil, newError(HostFunctionFailed, "error: method \"%s\" failed: %w", ref.Name, err)
+		}
+		out = out[:n-1]
+	}
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		d, err := r.reflector.lazy(out[0])
+		if err != nil {
+			return nil, withKind(HostFunctionFailed, err)
+		}
+		return d, nil
+	}
+	return nil, newError(HostFunctionFailed, "error: method \"%s\" returns %d values", ref.Name, len(out))
+}
@@ -0,0 +1,76 @@
+package smanchai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type methodUser struct{ Role string }
+
+func (u methodUser) HasRole(role string) bool { return u.Role == role }
+
+func (u methodUser) Delete() bool { return true }
+
+func TestMethodFilter(t *testing.T) {
+	user, err := Lazy(methodUser{Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnv()
+	env.Set("user", StaticValue(user))
+	tests := []struct {
+		src     string
+		methods MethodFilter
+		allowed bool
+	}{
+		{`@user.HasRole("admin")`, nil, false},
+		{`@user.HasRole("admin")`, AllowMethods("HasRole"), true},
+		{`@user.HasRole("admin")`, AllowMethods("methodUser.HasRole"), true},
+		{`@user.Delete()`, AllowMethods("HasRole"), false},
+		{`@user.Delete()`, AllowAllMethods, true},
+	}
+	for _, tt := range tests {
+		p := compile(t, tt.src)
+		p.options.Methods = tt.methods
+		r, err := p.Run(context.Background(), env)
+		if !tt.allowed {
+			if !errors.Is(err, MissingAttribute) || !errors.Is(err, ErrMethodNotAllowed) {
+				t.Errorf("%s = %v, %v, want %v", tt.src, r, err, ErrMethodNotAllowed)
+			}
+			continue
+		}
+		if b, _ := r.Bool(); err != nil || !b {
+			t.Errorf("%s = %v, %v, want true", tt.src, r, err)
+		}
+	}
+}
+
+func TestMethodMissing(t *testing.T) {
+	user, err := Lazy(methodUser{Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnv()
+	env.Set("user", StaticValue(user))
+	p := compile(t, `@user.IsAdmin()`).WithOptions(Options{Methods: AllowAllMethods})
+	_, err = p.Run(context.Background(), env)
+	if !errors.Is(err, MissingAttribute) || errors.Is(err, ErrMethodNotAllowed) {
+		t.Errorf("got %v, want a missing method", err)
+	}
+}
+
+func TestMethodOnEagerValue(t *testing.T) {
+	user, err := Reflect(methodUser{Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnv()
+	env.Set("user", StaticValue(user))
+	p := compile(t, `@user.HasRole("admin")`).WithOptions(Options{Methods: AllowAllMethods})
+	_, err = p.Run(context.Background(), env)
+	if !errors.Is(err, TypeMismatch) || !strings.Contains(err.Error(), "Lazy") {
+		t.Errorf("got %v, want an error pointing to Lazy", err)
+	}
+}
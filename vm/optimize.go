@@ -0,0 +1,145 @@
+package smanchai
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// loadOps is the opcode loading a constant of each foldable type.
+var loadOps = map[dataType]int{
+	DTypeInt:     Op_iload,
+	DTypeBool:    Op_iload,
+	DTypeChar:    Op_iload,
+	DTypeDouble:  Op_dload,
+	DTypeString:  Op_sload,
+	DTypeDecimal: Op_mload,
+}
+
+// pure reports whether the code in insts only computes on constants, so
+// running it once at compile time gives the same result as every Run.
+func pure(insts []int) bool {
+	for pc := 0; pc < len(insts); pc += 1 + operands[insts[pc]] {
+		switch insts[pc] {
+		case Op_getstatic, Op_getattr, Op_invoke:
+			return false
+		}
+	}
+	return true
+}
+
+// fold replaces the code emitted since mark by a single load of its result
+// when that code is pure. Code that fails, such as 1m / 0m, is left alone so
+// the error is still reported by Run.
+func (v *Visitor) fold(mark int, cmark int) {
+	code := v.insts[mark:]
+	if len(code) <= 2 || !pure(code) {
+		return
+	}
+	r, err := (&Program{options: v.opts, consts: v.consts, insts: code}).Run(context.Background(), nil)
+	if err != nil || r == nil {
+		return
+	}
+	op, o := loadOps[r.Type]
+	if !o {
+		return
+	}
+	v.insts, v.pos = v.insts[:mark], v.pos[:mark]
+	for _, c := range v.consts[cmark:] {
+		if k, o := constKey(c); o && v.constIndex[k] >= cmark {
+			delete(v.constIndex, k)
+		}
+	}
+	v.consts = v.consts[:cmark]
+	v.emitConst(op, r)
+}
+
+// constAt returns the constant loaded by code if it is a single load.
+func (v *Visitor) constAt(code []int) (*Data, bool) {
+	if len(code) != 2 {
+		return nil, false
+	}
+	for _, op := range loadOps {
+		if code[0] == op {
+			return v.consts[code[1]], true
+		}
+	}
+	return nil, false
+}
+
+// constKey identifies a constant for de-duplication. Two constants with the
+// same key can share a slot in the pool.
+func constKey(d *Data) (string, bool) {
+	var k string
+	switch d.Type {
+	case DTypeInt, DTypeChar:
+		i, _ := intValue(d.Value)
+		k = strconv.FormatInt(i, 10)
+	case DTypeBool:
+		k = strconv.FormatBool(boolValue(d.Value))
+	case DTypeDouble:
+		k = strconv.FormatFloat(toFloat64(d.Value), 'g', -1, 64)
+	case DTypeString:
+		k = d.Value.(string)
+	case DTypeDecimal:
+		k = d.Value.(Decimal).get().RatString()
+	case DTypeDataRef:
+		k = fmt.Sprint(*d.Value.(*DataRef))
+	case DTypeAttrRef:
+		k = fmt.Sprint(*d.Value.(*AttrRef))
+	case DTypeMethodRef:
+		k = fmt.Sprint(*d.Value.(*MethodRef))
+	default:
+		return "", false
+	}
+	return strconv.Itoa(int(d.Type)) + ":" + k, true
+}
+
+// simplify removes the operand of and/or emitted between mark and split, or
+// after split, when it is the identity of the operator and the other operand
+// is known to be a bool: true and x, x and true, false or x, x or false.
+func (v *Visitor) simplify(identity bool, mark int, split int, left *Node, right *Node) bool {
+	if c, o := v.constAt(v.insts[mark:split]); o && c.Type == DTypeBool && boolValue(c.Value) == identity && inferType(right) == DTypeBool {
+		v.insts = append(v.insts[:mark], v.insts[split:]...)
+		v.pos = append(v.pos[:mark], v.pos[split:]...)
+		return true
+	}
+	if c, o := v.constAt(v.insts[split:]); o && c.Type == DTypeBool && boolValue(c.Value) == identity && inferType(left) == DTypeBool {
+		v.insts, v.pos = v.insts[:split], v.pos[:split]
+		return true
+	}
+	return false
+}
+
+// constOperand reports whether the operand of op is an index in the pool.
+func constOperand(op int) bool {
+	return operands[op] == 1 && op != Op_iinc && op != Op_dinc && op != Op_conv
+}
+
+// compact drops constants no instruction refers to any more, which simplify
+// leaves behind, and renumbers the rest.
+func (v *Visitor) compact() {
+	index := make([]int, len(v.consts))
+	for i := range index {
+		index[i] = -1
+	}
+	consts := make([]*Data, 0, len(v.consts))
+	for pc := 0; pc < len(v.insts); pc += 1 + operands[v.insts[pc]] {
+		if !constOperand(v.insts[pc]) {
+			continue
+		}
+		i := v.insts[pc+1]
+		if index[i] < 0 {
+			index[i] = len(consts)
+			consts = append(consts, v.consts[i])
+		}
+		v.insts[pc+1] = index[i]
+	}
+	for _, c := range consts {
+		if c.Type == DTypeAttrRef {
+			ref := c.Value.(*AttrRef)
+			ref.Root = index[ref.Root]
+		}
+	}
+	v.consts = consts
+}
@@ -0,0 +1,122 @@
+package smanchai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func compileWith(t *testing.T, src string, opts Options) *Program {
+	t.Helper()
+	p, err := CompileWith(NewParser(NewLexer(strings.NewReader(src))).Parse(), opts)
+	if err != nil {
+		t.Fatalf("compile %q: %v", src, err)
+	}
+	return p
+}
+
+func TestOptimizeDisassembly(t *testing.T) {
+	tests := []struct {
+		src, folded, unfolded string
+	}{
+		{`1 + 2 * 3`, `
+#0 float 7
+0000  1:1   dload #0            ; 7
+`, `
+#0 float 1
+#1 float 2
+#2 float 3
+0000  1:1   dload #0            ; 1
+0002  1:5   dload #1            ; 2
+0004  1:9   dload #2            ; 3
+0006  1:5   dmul
+0007  1:1   dadd
+`},
+		{`"a" + "b" + @s`, `
+#0 string "ab"
+#1 dataref static "s"
+0000  1:1   sload #0            ; "ab"
+0002  1:13  getstatic #1        ; @s
+0004  1:1   sconcat
+`, `
+#0 string "a"
+#1 string "b"
+#2 dataref static "s"
+0000  1:1   sload #0            ; "a"
+0002  1:7   sload #1            ; "b"
+0004  1:1   sconcat
+0005  1:13  getstatic #2        ; @s
+0007  1:1   sconcat
+`},
+		{`true and @x == 1`, `
+#0 dataref static "x"
+#1 float 1
+0000  1:10  getstatic #0        ; @x
+0002  1:16  dload #1            ; 1
+0004  1:10  cmp_eq
+`, `
+#0 bool true
+#1 dataref static "x"
+#2 float 1
+0000  1:1   iload #0            ; true
+0002  1:10  getstatic #1        ; @x
+0004  1:16  dload #2            ; 1
+0006  1:10  cmp_eq
+0007  1:1   band
+`},
+	}
+	for _, tt := range tests {
+		if got := Disassemble(compileWith(t, tt.src, Options{})); got != tt.folded[1:] {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.src, got, tt.folded[1:])
+		}
+		if got := Disassemble(compileWith(t, tt.src, Options{NoOptimize: true})); got != tt.unfolded[1:] {
+			t.Errorf("%s with NoOptimize: got\n%s\nwant\n%s", tt.src, got, tt.unfolded[1:])
+		}
+	}
+}
+
+func TestOptimizeKeepsResults(t *testing.T) {
+	tests := []string{
+		`1 + 2 * 3 - 4 / 8`,
+		`2 ** 3 ** 2`,
+		`1.5m * 2m + 0.25m`,
+		`decimal("0.1") + 0.2m`,
+		`"n=" + string(int("42") + 1)`,
+		`"a" + "b" + @s`,
+		`true and @ok`,
+		`@ok and true`,
+		`false or @ok`,
+		`@ok or false`,
+		`true and @n`,
+		`false and @ok`,
+		`1 < 2 and "a" < "b"`,
+		`@n * 2 + 3`,
+		`@n ** 2 ** 0.5`,
+		`1 / 0`,
+		`1m / 0m`,
+		`int("x")`,
+	}
+	env := NewEnv()
+	env.Set("s", StaticValue(NewString("c")))
+	env.Set("ok", StaticValue(NewBool(true)))
+	env.Set("n", StaticValue(NewFloat(4)))
+	for _, src := range tests {
+		folded, ferr := compileWith(t, src, Options{}).Run(context.Background(), env)
+		plain, perr := compileWith(t, src, Options{NoOptimize: true}).Run(context.Background(), env)
+		if (ferr == nil) != (perr == nil) {
+			t.Errorf("%s: got errors %v and %v", src, ferr, perr)
+			continue
+		}
+		if ferr != nil {
+			var fe, pe *RuntimeError
+			if !errors.As(ferr, &fe) || !errors.As(perr, &pe) || fe.Kind != pe.Kind {
+				t.Errorf("%s: got errors %v and %v", src, ferr, perr)
+			}
+			continue
+		}
+		if !folded.Equal(plain) {
+			t.Errorf("%s: folded to %s %s, want %s %s", src, typeName(folded), folded, typeName(plain), plain)
+		}
+	}
+}
@@ -7,6 +7,8 @@ type Parser struct {
 	current   *TokenInfo
 	prev      *TokenInfo
 	on_unnext bool
+	last      Range // last token taken, where the node being parsed ends
+	undo      Range // last before the most recent next, restored by unnext
 }
 
 func NewParser(lexer *Lexer) *Parser {
@@ -23,6 +25,7 @@ func (p *Parser) next() (Range, Token, string) {
 		p.prev = &current
 		p.current = nil
 		p.on_unnext = false
+		p.take(current.r, current.token)
 		return current.r, current.token, current.str
 	} else {
 		r, token, str := p.lexer.Lex()
@@ -36,6 +39,7 @@ func (p *Parser) next() (Range, Token, string) {
 		}
 		prev := *p.current
 		p.prev = &prev
+		p.take(r, token)
 		return r, token, str
 	}
 }
@@ -55,6 +59,20 @@ func (p *Parser) unnext() {
 	prev := *p.prev
 	p.current = &prev
 	p.prev = nil
+	p.last = p.undo
+}
+
+func (p *Parser) take(r Range, token Token) {
+	p.undo = p.last
+	if token != WS && token != EOF {
+		p.last = r
+	}
+}
+
+// span returns the Range from start to the end of the last token taken.
+func (p *Parser) span(start Range) Range {
+	start.End = p.last.End
+	return start
 }
 
 func (p *Parser) Parse() *Node {
@@ -77,65 +95,60 @@ func (p *Parser) astProgram() *Node {
 	if o := p.astDisjunction(); o != nil {
 		children = append(children, o)
 		program.Children = children
+		program.Source = p.lexer.Source()
 		return obj
 	}
 	return nil
 }
 
 func (p *Parser) astDisjunction() *Node {
-	obj := &Node{
-		Type: AstDisjunction,
+	left := p.astConjunction()
+	if left == nil {
+		return nil
 	}
-	disj := &DisjunctionNode{}
-	obj.Object = disj
-	if left := p.astConjunction(); left != nil {
+	for {
 		p.skip_whitespace()
-		r, token, _ := p.next()
-		obj.Range = r
-		switch token {
-		case DISJUNCTION:
-			p.skip_whitespace()
-			if right := p.astConjunction(); right != nil {
-				disj.Left = left
-				disj.Right = right
-				return obj
-			} else {
-				panic("")
-			}
-		default:
+		_, token, _ := p.next()
+		if token != DISJUNCTION {
 			p.unnext()
 			return left
 		}
+		p.skip_whitespace()
+		right := p.astConjunction()
+		if right == nil {
+			panic("")
+		}
+		left = &Node{
+			Type:   AstDisjunction,
+			Range:  p.span(left.Range),
+			Object: &DisjunctionNode{Left: left, Right: right},
+		}
 	}
-	return nil
 }
 
 func (p *Parser) astConjunction() *Node {
-	obj := &Node{
-		Type: AstConjunction,
+	left := p.astEqaulity()
+	if left == nil {
+		return nil
 	}
-	conj := &ConjunctionNode{}
-	obj.Object = conj
-	if left := p.astEqaulity(); left != nil {
+	for {
 		p.skip_whitespace()
-		r, token, _ := p.next()
-		obj.Range = r
-		switch token {
-		case CONJUNCTION:
-			p.skip_whitespace()
-			if right := p.astEqaulity(); right != nil {
-				conj.Left = left
-				conj.Right = right
-				return obj
-			} else {
-				panic("")
-			}
-		default:
+		_, token, _ := p.next()
+		if token != CONJUNCTION {
 			p.unnext()
 			return left
 		}
+		p.skip_whitespace()
+		right := p.astEqaulity()
+		if right == nil {
+			panic("")
+		}
+		left = &Node{
+			Type:   AstConjunction,
+			Range:  p.span(left.Range),
+			Object: &ConjunctionNode{Left: left, Right: right},
+		}
 	}
-	return nil
 }
 
 func (p *Parser) astEqaulity() *Node {
@@ -146,8 +159,7 @@ func (p *Parser) astEqaulity() *Node {
 	obj.Object = expr
 	if left := p.astComparison(); left != nil {
 		p.skip_whitespace()
-		r, token, str := p.next()
-		obj.Range = r
+		_, token, str := p.next()
 		expr.Left = left
 		switch token {
 		case EQUALITY_OPERATOR:
@@ -163,6 +175,7 @@ func (p *Parser) astEqaulity() *Node {
 			p.skip_whitespace()
 			if right := p.astComparison(); right != nil {
 				expr.Right = right
+				obj.Range = p.span(left.Range)
 				return obj
 			} else {
 				panic("")
@@ -183,8 +196,7 @@ func (p *Parser) astComparison() *Node {
 	obj.Object = expr
 	if left := p.astAdditiveExpression(); left != nil {
 		p.skip_whitespace()
-		r, token, str := p.next()
-		obj.Range = r
+		_, token, str := p.next()
 		expr.Left = left
 		switch token {
 		case COMPARISON_OPERATOR:
@@ -204,6 +216,7 @@ func (p *Parser) astComparison() *Node {
 			p.skip_whitespace()
 			if right := p.astAdditiveExpression(); right != nil {
 				expr.Right = right
+				obj.Range = p.span(left.Range)
 				return obj
 			} else {
 				panic("")
@@ -217,79 +230,63 @@ func (p *Parser) astComparison() *Node {
 }
 
 func (p *Parser) astAdditiveExpression() *Node {
-	obj := &Node{
-		Type: AstExpression,
+	left := p.astMultiplicativeExpression()
+	if left == nil {
+		return nil
 	}
-	expr := &ExpressionNode{}
-	obj.Object = expr
-	if left := p.astMultiplicativeExpression(); left != nil {
+	for {
 		p.skip_whitespace()
-		r, token, _ := p.next()
-		obj.Range = r
-		expr.Left = left
+		_, token, _ := p.next()
+		expr := &ExpressionNode{Left: left}
 		switch token {
 		case ADD:
 			expr.Op = EOpADD
-			p.skip_whitespace()
-			if right := p.astMultiplicativeExpression(); right != nil {
-				expr.Right = right
-				return obj
-			} else {
-				panic("")
-			}
 		case SUB:
 			expr.Op = EOprSUB
-			p.skip_whitespace()
-			if right := p.astMultiplicativeExpression(); right != nil {
-				expr.Right = right
-				return obj
-			} else {
-				panic("")
-			}
 		default:
 			p.unnext()
+			return left
+		}
+		p.skip_whitespace()
+		if expr.Right = p.astMultiplicativeExpression(); expr.Right == nil {
+			panic("")
+		}
+		left = &Node{
+			Type:   AstExpression,
+			Range:  p.span(left.Range),
+			Object: expr,
 		}
-		return left
 	}
-	return nil
 }
 
 func (p *Parser) astMultiplicativeExpression() *Node {
-	obj := &Node{
-		Type: AstExpression,
+	left := p.astExponentialExpression()
+	if left == nil {
+		return nil
 	}
-	expr := &ExpressionNode{}
-	obj.Object = expr
-	if left := p.astExponentialExpression(); left != nil {
+	for {
 		p.skip_whitespace()
-		r, token, _ := p.next()
-		obj.Range = r
-		expr.Left = left
+		_, token, _ := p.next()
+		expr := &ExpressionNode{Left: left}
 		switch token {
 		case MULT:
 			expr.Op = EOprMULT
-			p.skip_whitespace()
-			if right := p.astExponentialExpression(); right != nil {
-				expr.Right = right
-				return obj
-			} else {
-				panic("")
-			}
 		case DIV:
 			expr.Op = EOprDIV
-			p.skip_whitespace()
-			if right := p.astExponentialExpression(); right != nil {
-				expr.Right = right
-				return obj
-			} else {
-				panic("")
-			}
 		default:
 			p.unnext()
+			return left
+		}
+		p.skip_whitespace()
+		if expr.Right = p.astExponentialExpression(); expr.Right == nil {
+			panic("")
+		}
+		left = &Node{
+			Type:   AstExpression,
+			Range:  p.span(left.Range),
+			Object: expr,
 		}
-		return left
 	}
-	return nil
 }
 
 func (p *Parser) astExponentialExpression() *Node {
@@ -302,11 +299,12 @@ func (p *Parser) astExponentialExpression() *Node {
 		switch left.Type {
 		case AstPrimitive:
 			p.skip_whitespace()
-			if r, token, _ := p.next(); token == POW {
+			if _, token, _ := p.next(); token == POW {
 				p.skip_whitespace()
 				expr.Left = left
-				if right := p.astPrimitive(); right != nil {
-					obj.Range = r
+				// ** is right-associative: 2 ** 3 ** 2 is 2 ** (3 ** 2)
+				if right := p.astExponentialExpression(); right != nil {
+					obj.Range = p.span(left.Range)
 					expr.Op = EOprPOW
 					expr.Right = right
 					return obj
@@ -335,16 +333,14 @@ func (p *Parser) astPrimitive() *Node {
 		Type:  AstPrimitive,
 		Range: r,
 	}
-	if o := p.astFunction(); o != nil {
-		obj.Object = o
-		return obj
-	}
 	if o := p.astIdentifier(); o != nil {
 		obj.Object = o
+		obj.Range = o.Range
 		return obj
 	}
 	if o := p.astLiteral(); o != nil {
 		obj.Object = o
+		obj.Range = o.Range
 		return obj
 	}
 	return nil
@@ -363,6 +359,13 @@ func (p *Parser) astLiteral() *Node {
 		}
 		return obj
 	}
+	if token == DECIMAL {
+		obj.Object = &LiteralNode{
+			Type: LDECIMAL,
+			Raw:  str,
+		}
+		return obj
+	}
 	if token == STRING {
 		obj.Object = &LiteralNode{
 			Type: LSTRING,
@@ -380,12 +383,41 @@ func (p *Parser) astLiteral() *Node {
 	return nil
 }
 
-func (p *Parser) astFunction() *Node {
-	return nil
+func (p *Parser) astFunction(r Range, name string) *Node {
+	params := make([]*Node, 0, 4)
+	p.skip_whitespace()
+	if _, token, _ := p.next(); token != RParent {
+		p.unnext()
+		for {
+			p.skip_whitespace()
+			param := p.astDisjunction()
+			if param == nil {
+				panic(fmt.Sprintf("Error: Expected argument of \"%s\" at %d:%d\n", name, r.Line, r.Column))
+			}
+			params = append(params, param)
+			p.skip_whitespace()
+			r, token, str := p.next()
+			if token == RParent {
+				break
+			}
+			if token != COMMA {
+				panic(fmt.Sprintf("Error: Invalid or unexpected token at %d:%d, \"%s\"\n", r.Line, r.Column, str))
+			}
+		}
+	}
+	return &Node{
+		Type:  AstFunction,
+		Range: p.span(r),
+		Object: &FunctionNode{
+			Name:   name,
+			Params: params,
+		},
+	}
 }
 
 func (p *Parser) astIdentifier() *Node {
 	r, token, str := p.next()
+	start := r
 	base := ""
 	subIdentifier := make([]string, 0, 256)
 	if token == AT {
@@ -411,9 +443,32 @@ func (p *Parser) astIdentifier() *Node {
 				subIdentifier = append(subIdentifier, str)
 			}
 		}
+		recv := p.span(start)
+		if _, token, _ := p.next(); token == LParent && len(subIdentifier) > 0 {
+			name := subIdentifier[len(subIdentifier)-1]
+			call := p.astFunction(start, name).Object.(*FunctionNode)
+			return &Node{
+				Type:  AstMethod,
+				Range: p.span(start),
+				Object: &MethodNode{
+					Receiver: &Node{
+						Type:  AstIdentifier,
+						Range: recv,
+						Object: &IdentifierNode{
+							At:            true,
+							Base:          base,
+							SubIdentifier: subIdentifier[:len(subIdentifier)-1],
+						},
+					},
+					Name:   name,
+					Params: call.Params,
+				},
+			}
+		}
+		p.unnext()
 		return &Node{
 			Type:  AstIdentifier,
-			Range: r,
+			Range: p.span(start),
 			Object: &IdentifierNode{
 				At:            true,
 				Base:          base,
@@ -423,6 +478,10 @@ func (p *Parser) astIdentifier() *Node {
 	}
 	if token == IDENTIFIER {
 		base = str
+		if _, token, _ := p.next(); token == LParent {
+			return p.astFunction(start, base)
+		}
+		p.unnext()
 		for {
 			if _, token, _ := p.next(); token != DOT {
 				p.unnext()
@@ -444,7 +503,7 @@ func (p *Parser) astIdentifier() *Node {
 		}
 		return &Node{
 			Type:  AstIdentifier,
-			Range: r,
+			Range: p.span(start),
 			Object: &IdentifierNode{
 				At:            false,
 				Base:          base,
@@ -0,0 +1,56 @@
+package smanchai
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseLeftAssociative(t *testing.T) {
+	tests := []struct {
+		src  string
+		want AstType
+	}{
+		{"@a - @b - @c", AstExpression},
+		{"@a / @b * @c", AstExpression},
+		{"@a and @b and @c", AstConjunction},
+		{"@a or @b or @c", AstDisjunction},
+	}
+	for _, tt := range tests {
+		node := NewParser(NewLexer(strings.NewReader(tt.src))).Parse()
+		root := node.Object.(*ProgramNode).Children[0]
+		left, right := children(root)
+		if root.Type != tt.want || left.Type != tt.want || right.Type != AstPrimitive {
+			t.Errorf("%s: parsed as %s(%s, %s), want %s(%s, %s)", tt.src, root.Type, left.Type, right.Type, tt.want, tt.want, AstType(AstPrimitive))
+		}
+	}
+}
+
+func TestParsePowRightAssociative(t *testing.T) {
+	node := NewParser(NewLexer(strings.NewReader("@a ** @b ** @c"))).Parse()
+	root := node.Object.(*ProgramNode).Children[0]
+	left, right := children(root)
+	if root.Type != AstExpression || left.Type != AstPrimitive || right.Type != AstExpression {
+		t.Fatalf("parsed as %s(%s, %s), want %s(%s, %s)", root.Type, left.Type, right.Type, AstType(AstExpression), AstType(AstPrimitive), AstType(AstExpression))
+	}
+	for src, want := range map[string]float64{"2 ** 3 ** 2": 512, "2 ** 2 ** 2 ** 2": 65536, "2 * 3 ** 2": 18} {
+		for _, opts := range []Options{{}, {NoOptimize: true}} {
+			r, err := compileWith(t, src, opts).Run(context.Background(), nil)
+			if err != nil || !r.Equal(NewFloat(want)) {
+				t.Errorf("%s: got %s, %v, want %g", src, r, err, want)
+			}
+		}
+	}
+}
+
+func children(node *Node) (*Node, *Node) {
+	switch o := node.Object.(type) {
+	case *ExpressionNode:
+		return o.Left, o.Right
+	case *ConjunctionNode:
+		return o.Left, o.Right
+	case *DisjunctionNode:
+		return o.Left, o.Right
+	}
+	return nil, nil
+}
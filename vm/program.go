@@ -0,0 +1,96 @@
+package smanchai
+
+import (
+	"context"
+	"sync"
+)
+
+// Options control how a Program is compiled and run. A Program keeps its own
+// copy; see Program.Options and Program.WithOptions.
+type Options struct {
+	NoOptimize bool // skip constant folding and constant de-duplication, for debugging
+
+	DecimalScale    int // fractional digits kept by Op_mdiv and Op_mexp
+	DecimalRounding RoundingMode
+	StrictBool      bool                  // reject non-bool operands of and/or instead of using truthiness
+	Methods         MethodFilter          // Go methods rules may call, none when nil; see AllowMethods
+	StrictCompare   bool                  // comparing incompatible types is an error instead of false
+	Collation       func(a, b string) int // string ordering for < and >, strings.Compare when nil
+	Limits          Limits
+}
+
+// Limits bound the work a single Run may do. A zero field means no limit.
+// Exceeding one fails the Run with a LimitExceeded RuntimeError wrapping the
+// matching ErrInstructionLimit, ErrStackLimit, ErrStringLimit or
+// ErrCollectionLimit.
+type Limits struct {
+	Instructions   int // instructions executed
+	StackDepth     int // operands on the stack at once
+	StringLength   int // bytes in a string made by Op_sconcat
+	CollectionSize int // items in an array, object or Go value read from the Env or a method
+}
+
+// Program is a compiled rule. Its code, constants and options are never
+// modified after Compile, so a single Program may be run by any number of
+// goroutines at once.
+type Program struct {
+	options Options
+	consts  []*Data
+	insts   []int
+	ranges  []pcRange
+	source  string
+}
+
+// pcRange maps the instructions from PC up to the next entry to the source
+// position they were compiled from.
+type pcRange struct {
+	PC    int
+	Range Range
+}
+
+// Options returns a copy of the options p was compiled with.
+func (p *Program) Options() Options {
+	return p.options
+}
+
+// WithOptions returns a Program sharing the code of p that runs with opts,
+// such as a decoded Program given the Methods, Collation and Limits its binary
+// form does not store. p itself is unchanged.
+func (p *Program) WithOptions(opts Options) *Program {
+	q := *p
+	q.options = opts
+	return &q
+}
+
+var vmPool = sync.Pool{
+	New: func() any {
+		return &VM{operand: stack[*Data]{arr: make([]*Data, 0, 256)}}
+	},
+}
+
+// Run evaluates the program against env. Every call gets its own operand stack
+// and program counter. The run stops with a Canceled RuntimeError once ctx is
+// done, and ctx is passed to Go methods whose first parameter is a
+// context.Context. A Tracer passed with WithTracer sees every step.
+func (p *Program) Run(ctx context.Context, env *Env, opts ...RunOption) (*Data, error) {
+	vm := vmPool.Get().(*VM)
+	vm.Program, vm.ctx, vm.env, vm.pc, vm.steps = p, ctx, env, 0, 0
+	for _, opt := range opts {
+		opt(vm)
+	}
+	defer func() {
+		clear(vm.operand.arr[:cap(vm.operand.arr)])
+		vm.operand.arr = vm.operand.arr[:0]
+		vm.Program, vm.ctx, vm.env, vm.tracer = nil, nil, nil, nil
+		vmPool.Put(vm)
+	}()
+	r, err := vm.run()
+	if err != nil {
+		err = vm.errorAt(err)
+		if vm.tracer != nil {
+			vm.tracer.OnError(err)
+		}
+		return nil, err
+	}
+	return r, nil
+}
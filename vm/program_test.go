@@ -0,0 +1,78 @@
+package smanchai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProgramConcurrentRuns(t *testing.T) {
+	p := compile(t, `string(@a * 2 + @b) + @suffix`)
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				env := NewEnv()
+				env.Set("a", StaticValue(NewFloat(float64(g))))
+				env.Set("b", StaticValue(NewFloat(float64(i))))
+				env.Set("suffix", StaticValue(NewString(fmt.Sprintf("/%d", g))))
+				r, err := p.Run(context.Background(), env)
+				want := fmt.Sprintf("%d/%d", 2*g+i, g)
+				if err != nil || r.String() != want {
+					errs <- fmt.Errorf("goroutine %d run %d: got %s, %v, want %s", g, i, r, err, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestRunLeavesConstantsAlone(t *testing.T) {
+	for _, src := range []string{`"hi"`, `1.5m`, `"a" + "b" + @s`, `1.25m * @m`} {
+		p := compile(t, src)
+		before := make([]*Data, len(p.consts))
+		for i, c := range p.consts {
+			before[i] = c.Clone()
+		}
+		env := NewEnv()
+		env.Set("s", StaticValue(NewString("c")))
+		env.Set("m", StaticValue(NewDecimal(MustParseDecimal("2"))))
+		r, err := p.Run(context.Background(), env)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		// callers own the result and may change it
+		r.Value = "changed"
+		for i, c := range p.consts {
+			if !c.Equal(before[i]) {
+				t.Errorf("%s: constant #%d changed from %s to %s", src, i, before[i], c)
+			}
+		}
+	}
+}
+
+func TestProgramOptionsCopy(t *testing.T) {
+	p, err := CompileWith(NewParser(NewLexer(strings.NewReader(`1m / 3m`))).Parse(), Options{DecimalScale: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := p.Options()
+	opts.DecimalScale = 10
+	if p.Options().DecimalScale != 2 {
+		t.Errorf("changing a copy of the options changed the Program")
+	}
+	q := p.WithOptions(opts)
+	if p.Options().DecimalScale != 2 || q.Options().DecimalScale != 10 {
+		t.Errorf("WithOptions: got scales %d and %d, want 2 and 10", p.Options().DecimalScale, q.Options().DecimalScale)
+	}
+}
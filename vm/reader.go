@@ -3,6 +3,7 @@ package smanchai
 import (
 	"bufio"
 	"fmt"
+	"sort"
 	"unicode/utf8"
 )
 
@@ -12,6 +13,8 @@ type Reader struct {
 	pad    int
 	unread int
 	buffer []rune
+	source []rune // every rune read so far, never cleaned up
+	lines  []int  // index in source of the first rune of every line but the first
 	reader *bufio.Reader
 }
 
@@ -38,6 +41,10 @@ func (r *Reader) ReadRune() (rune, int, error) {
 	c, s, err := r.reader.ReadRune()
 	if err == nil {
 		r.buffer = append(r.buffer, c)
+		r.source = append(r.source, c)
+		if c == '\n' {
+			r.lines = append(r.lines, len(r.source))
+		}
 		r.index++
 		r.mark++
 	}
@@ -53,6 +60,16 @@ func (r *Reader) UnreadRune() error {
 	return nil
 }
 
+// position returns the 1-based line and column of the rune at index i of
+// source.
+func (r *Reader) position(i int) (int, int) {
+	n := sort.SearchInts(r.lines, i+1)
+	if n == 0 {
+		return 1, i + 1
+	}
+	return n + 1, i - r.lines[n-1] + 1
+}
+
 func (r *Reader) CleanUp() {
 	if r.unread > 0 {
 		r.buffer = r.buffer[len(r.buffer)-r.unread:]
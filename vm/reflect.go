@@ -1,27 +1,137 @@
 package smanchai
 
 import (
+	"encoding"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"strconv"
 )
 
+// Reflector converts Go values into Data. The zero value is ready to use and is
+// what Reflect uses.
+type Reflector struct {
+	BytesAsArray   bool // convert []byte to an array of ints instead of a string
+	SkipUnexported bool // leave unexported struct fields out of objects
+
+	Converters map[reflect.Type]Converter // per-Reflector overrides of RegisterConverter
+}
+
 func Reflect(data any) (*Data, error) {
-	return toData(reflect.ValueOf(data).Kind(), reflect.ValueOf(data))
+	return (&Reflector{}).Reflect(data)
+}
+
+// Reflect converts data into a Data tree. Nil pointers, interfaces, maps and
+// slices become nil (undefined). Self-referential values are rejected. The
+// tree keeps no link to data, so rules cannot call its methods; use Lazy for
+// that.
+func (r *Reflector) Reflect(data any) (*Data, error) {
+	return r.reflectValue(addressable(reflect.ValueOf(data)))
+}
+
+// addressable returns a settable copy of data so that pointer-receiver
+// SmanchaiValuer methods can be called on it and its fields.
+func addressable(data reflect.Value) reflect.Value {
+	if !data.IsValid() || data.CanAddr() {
+		return data
+	}
+	v := reflect.New(data.Type()).Elem()
+	v.Set(data)
+	return v
+}
+
+type reflectVisit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+type reflectWalk struct {
+	*Reflector
+	seen map[reflectVisit]bool
+}
+
+var decimalType = reflect.TypeOf(Decimal{})
+
+func (w *reflectWalk) fail(data reflect.Value, path string) error {
+	if path == "" {
+		return fmt.Errorf("error: cannot reflect %s", data.Type())
+	}
+	return fmt.Errorf("error: cannot reflect %s at %s", data.Type(), path)
 }
 
-func toData(typ reflect.Kind, data reflect.Value) (*Data, error) {
-	switch typ {
+// enter marks a pointer-like value as being converted, reporting a cycle if it
+// already is further up the current path.
+func (w *reflectWalk) enter(data reflect.Value, path string) (func(), error) {
+	visit := reflectVisit{ptr: uintptr(data.UnsafePointer()), typ: data.Type()}
+	if data.Kind() == reflect.Slice {
+		visit.len = data.Len()
+	}
+	if w.seen[visit] {
+		if path == "" {
+			return nil, fmt.Errorf("error: cycle detected in %s", data.Type())
+		}
+		return nil, fmt.Errorf("error: cycle detected in %s at %s", data.Type(), path)
+	}
+	w.seen[visit] = true
+	return func() { delete(w.seen, visit) }, nil
+}
+
+func (w *reflectWalk) toData(data reflect.Value, path string) (*Data, error) {
+	if !data.IsValid() {
+		return nil, nil
+	}
+	if d, o, err := w.custom(data); o {
+		if err != nil {
+			if path == "" {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w at %s", err, path)
+		}
+		return d, nil
+	}
+	if data.Type() == decimalType {
+		if !data.CanInterface() {
+			// unexported field; Interface() would panic so read the rat directly
+			return &Data{
+				Type:  DTypeDecimal,
+				Value: Decimal{rat: (*big.Rat)(data.Field(0).UnsafePointer())},
+			}, nil
+		}
+		return &Data{
+			Type:  DTypeDecimal,
+			Value: data.Interface().(Decimal),
+		}, nil
+	}
+	switch data.Kind() {
+	case reflect.Pointer:
+		if data.IsNil() {
+			return nil, nil
+		}
+		leave, err := w.enter(data, path)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		return w.toData(data.Elem(), path)
+	case reflect.Interface:
+		if data.IsNil() {
+			return nil, nil
+		}
+		return w.toData(data.Elem(), path)
 	case reflect.Struct:
 		omap := map[string]*Data{}
-		for i := 0; i < data.NumField(); i++ {
-			kind := data.Field(i).Kind()
-			name := data.Type().Field(i).Name
-			value := data.Field(i)
-			v, err := toData(kind, value)
+		for _, field := range structFields(data.Type(), w.SkipUnexported) {
+			value, o := fieldValue(data, field.index)
+			if !o || (field.omitEmpty && isEmptyValue(value)) {
+				continue
+			}
+			v, err := w.toData(value, joinPath(path, field.name))
 			if err != nil {
 				return nil, err
 			}
-			omap[name] = v
+			omap[field.name] = v
 		}
 		return &Data{
 			Type: DTypeObject,
@@ -29,55 +139,84 @@ func toData(typ reflect.Kind, data reflect.Value) (*Data, error) {
 				Data: omap,
 			},
 		}, nil
+	case reflect.Slice:
+		if data.IsNil() {
+			return nil, nil
+		}
+		if data.Type().Elem().Kind() == reflect.Uint8 && !w.BytesAsArray {
+			return &Data{
+				Type:  DTypeString,
+				Value: string(data.Bytes()),
+			}, nil
+		}
+		leave, err := w.enter(data, path)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		return w.toArray(data, path)
 	case reflect.Array:
-		oarr := []*Data{}
-		for i := 0; i < data.NumField(); i++ {
-			kind := data.Field(i).Kind()
-			value := data.Field(i)
-			v, err := toData(kind, value)
+		return w.toArray(data, path)
+	case reflect.Map:
+		if data.IsNil() {
+			return nil, nil
+		}
+		leave, err := w.enter(data, path)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		omap := make(map[string]*Data, data.Len())
+		iter := data.MapRange()
+		for iter.Next() {
+			key, err := mapKey(iter.Key())
+			if err != nil {
+				return nil, fmt.Errorf("%w at %s", err, joinPath(path, "<key>"))
+			}
+			v, err := w.toData(iter.Value(), joinPath(path, key))
 			if err != nil {
 				return nil, err
 			}
-			oarr = append(oarr, v)
+			omap[key] = v
 		}
 		return &Data{
-			Type: DTypeArray,
-			Value: &DataObjectArray{
-				Data: oarr,
+			Type: DTypeObject,
+			Value: &DataObjectMap{
+				Data: omap,
 			},
 		}, nil
 	case reflect.Int,
 		reflect.Int8,
 		reflect.Int16,
 		reflect.Int32,
-		reflect.Int64,
-		reflect.Uint,
+		reflect.Int64:
+		return &Data{
+			Type:  DTypeInt,
+			Value: int(data.Int()),
+		}, nil
+	case reflect.Uint,
 		reflect.Uint8,
 		reflect.Uint16,
 		reflect.Uint32,
-		reflect.Uint64:
+		reflect.Uint64,
+		reflect.Uintptr:
+		u := data.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: %d overflows int", w.fail(data, path), u)
+		}
 		return &Data{
 			Type:  DTypeInt,
-			Value: data.Int(),
+			Value: int(u),
 		}, nil
 	case reflect.Float32, reflect.Float64:
 		return &Data{
 			Type:  DTypeDouble,
 			Value: data.Float(),
 		}, nil
-	case reflect.Chan:
-		return &Data{
-			Type:  DTypeChar,
-			Value: data.Int(),
-		}, nil
 	case reflect.Bool:
-		m := 0
-		if data.Bool() {
-			m = 1
-		}
 		return &Data{
 			Type:  DTypeBool,
-			Value: m,
+			Value: data.Bool(),
 		}, nil
 	case reflect.String:
 		return &Data{
@@ -85,5 +224,49 @@ func toData(typ reflect.Kind, data reflect.Value) (*Data, error) {
 			Value: data.String(),
 		}, nil
 	}
-	return nil, fmt.Errorf("unsupported data type")
+	return nil, w.fail(data, path)
+}
+
+func (w *reflectWalk) toArray(data reflect.Value, path string) (*Data, error) {
+	oarr := make([]*Data, 0, data.Len())
+	for i := 0; i < data.Len(); i++ {
+		v, err := w.toData(data.Index(i), joinPath(path, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+		oarr = append(oarr, v)
+	}
+	return &Data{
+		Type: DTypeArray,
+		Value: &DataObjectArray{
+			Data: oarr,
+		},
+	}, nil
+}
+
+// mapKey turns a map key into an object attribute name. String and integer
+// kinds are used directly; other keys must implement encoding.TextMarshaler or
+// fmt.Stringer.
+func mapKey(key reflect.Value) (string, error) {
+	if key.CanInterface() {
+		switch k := key.Interface().(type) {
+		case encoding.TextMarshaler:
+			b, err := k.MarshalText()
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		case fmt.Stringer:
+			return k.String(), nil
+		}
+	}
+	switch key.Kind() {
+	case reflect.String:
+		return key.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("error: cannot use %s as an object key", key.Type())
 }
@@ -0,0 +1,140 @@
+package smanchai
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestReflectValues(t *testing.T) {
+	n := 7
+	pn := &n
+	type point struct {
+		X, Y int
+	}
+	tests := []struct {
+		in   any
+		want *Data
+	}{
+		{nil, nil},
+		{&n, NewInt(7)},
+		{&pn, NewInt(7)},
+		{(*int)(nil), nil},
+		{&point{1, 2}, NewObject().Put("X", NewInt(1)).Put("Y", NewInt(2))},
+		{[]int{1, 2}, NewArray(NewInt(1), NewInt(2))},
+		{[2]string{"a", "b"}, NewArray(NewString("a"), NewString("b"))},
+		{[]*int{&n, nil}, NewArray(NewInt(7), nil)},
+		{[]int(nil), nil},
+		{[]int{}, NewArray()},
+		{[]byte("hi"), NewString("hi")},
+		{map[string]int{"a": 1}, NewObject().Put("a", NewInt(1))},
+		{map[int]bool{-1: true, 2: false}, NewObject().Put("-1", NewBool(true)).Put("2", NewBool(false))},
+		{map[uint8]string{9: "x"}, NewObject().Put("9", NewString("x"))},
+		{map[string]int(nil), nil},
+		{uint8(255), NewInt(255)},
+		{uint32(math.MaxUint32), NewInt(math.MaxUint32)},
+		{uint64(math.MaxInt64), NewInt(math.MaxInt64)},
+		{uintptr(3), NewInt(3)},
+		{[]uint16{1, 2}, NewArray(NewInt(1), NewInt(2))},
+	}
+	for _, tt := range tests {
+		got, err := Reflect(tt.in)
+		if err != nil {
+			t.Errorf("%#v: %v", tt.in, err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(tt.want)) {
+			t.Errorf("%#v: got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReflectNilPointers(t *testing.T) {
+	type inner struct{ N int }
+	type outer struct {
+		P *inner
+		I any
+		M map[string]int
+		S []int
+	}
+	d, err := Reflect(&outer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := d.Value.(*DataObjectMap).Data
+	for _, name := range []string{"P", "I", "M", "S"} {
+		if v, o := attrs[name]; !o || v != nil {
+			t.Errorf("%s = %s, %v, want undefined", name, v, o)
+		}
+	}
+	if d, err := Reflect((*outer)(nil)); d != nil || err != nil {
+		t.Errorf("nil pointer: got %s, %v, want undefined", d, err)
+	}
+}
+
+func TestReflectUintOverflow(t *testing.T) {
+	type row struct{ ID uint64 }
+	tests := []any{
+		uint64(math.MaxInt64 + 1),
+		uint64(math.MaxUint64),
+		row{ID: math.MaxUint64},
+		[]uint64{1, math.MaxUint64},
+		map[string]uint{"n": math.MaxUint64},
+	}
+	for _, in := range tests {
+		if d, err := Reflect(in); err == nil || !strings.Contains(err.Error(), "overflows int") {
+			t.Errorf("Reflect(%v) = %s, %v, want an overflow error", in, d, err)
+		}
+	}
+	d, err := Lazy(row{ID: math.MaxUint64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Value.(*DataReflect).Attr("ID"); err == nil {
+		t.Errorf("lazy ID = %s, want an overflow error", v)
+	}
+}
+
+type reflectNode struct {
+	Name string
+	Next *reflectNode
+	Kids []*reflectNode
+}
+
+func TestReflectCycles(t *testing.T) {
+	self := &reflectNode{Name: "self"}
+	self.Next = self
+	ring := &reflectNode{Name: "a", Next: &reflectNode{Name: "b"}}
+	ring.Next.Next = ring
+	kid := &reflectNode{Name: "kid"}
+	kid.Kids = []*reflectNode{{Name: "x", Next: kid}}
+	loop := map[string]any{}
+	loop["me"] = loop
+	list := []any{nil}
+	list[0] = list
+	for _, in := range []any{self, ring, kid, loop, list} {
+		if d, err := Reflect(in); err == nil || !strings.Contains(err.Error(), "cycle") {
+			t.Errorf("got %s, %v, want a cycle error", d, err)
+		}
+	}
+	// sharing without a cycle is fine
+	leaf := &reflectNode{Name: "leaf"}
+	d, err := Reflect(&reflectNode{Name: "root", Next: leaf, Kids: []*reflectNode{leaf, leaf}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Get("Kids.1.Name"); got == nil || got.Value != "leaf" {
+		t.Errorf("Kids.1.Name = %s, want leaf", got)
+	}
+}
+
+func TestReflectUnsupported(t *testing.T) {
+	for _, in := range []any{make(chan int), func() {}, complex(1, 2), map[[2]int]int{{1, 2}: 3}} {
+		if d, err := Reflect(in); err == nil {
+			t.Errorf("Reflect(%T) = %s, want an error", in, d)
+		}
+	}
+	if _, err := Reflect(struct{ C chan int }{}); err == nil || !strings.Contains(err.Error(), "at C") {
+		t.Errorf("got %v, want the path of the field", err)
+	}
+}
@@ -9,6 +9,7 @@ const (
 	IDENTIFIER                 // ✅
 	STRING                     // ✅
 	NUMBER                     // ✅
+	DECIMAL                    // ✅
 	BOOL                       // ✅
 	AT                         // ✅
 	ADD                        // ✅
@@ -33,6 +34,7 @@ var tokens = []string{
 	IDENTIFIER: "IDENTIFIER",
 	STRING:     "STRING",
 	NUMBER:     "NUMBER",
+	DECIMAL:    "DECIMAL",
 	BOOL:       "BOOL",
 	AT:         "AT",
 	ADD:        "ADD",
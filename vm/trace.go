@@ -0,0 +1,136 @@
+package smanchai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Tracer observes a Run. Pass one to Program.Run with WithTracer; a Run
+// without a tracer does no tracing work at all.
+type Tracer interface {
+	// OnInstruction is called before op at pc runs. stack is the operand
+	// stack, top last, and is only valid during the call.
+	OnInstruction(pc int, op Opcode, stack []*Data)
+	// OnStatic is called after the static @name was read from the Env.
+	OnStatic(name string, value *Data, dur time.Duration)
+	// OnCall is called after the Go method fn returned.
+	OnCall(fn string, args []*Data, result *Data)
+	// OnError is called with the error a Run fails with.
+	OnError(err error)
+}
+
+// RunOption configures a single Program.Run or Program.Debug.
+type RunOption func(vm *VM)
+
+// WithTracer makes the run report to t.
+func WithTracer(t Tracer) RunOption {
+	return func(vm *VM) {
+		vm.tracer = t
+	}
+}
+
+// TextTracer writes one line per event:
+//
+//	static @user = {"name": "bob"} (1.2µs)
+//	0002  getattr       [{"name": "bob"}]
+//	call HasRole("admin") = true
+//
+// Its OnToken method can be set as Lexer.Trace to list tokens as well.
+type TextTracer struct {
+	w io.Writer
+}
+
+func NewTextTracer(w io.Writer) *TextTracer {
+	return &TextTracer{w: w}
+}
+
+func (t *TextTracer) OnInstruction(pc int, op Opcode, stack []*Data) {
+	fmt.Fprintf(t.w, "%04d  %-13s [%s]\n", pc, mnemonic(int(op)), dataList(stack))
+}
+
+func (t *TextTracer) OnStatic(name string, value *Data, dur time.Duration) {
+	fmt.Fprintf(t.w, "static @%s = %s (%s)\n", name, traceText(value), dur)
+}
+
+func (t *TextTracer) OnCall(fn string, args []*Data, result *Data) {
+	fmt.Fprintf(t.w, "call %s(%s) = %s\n", fn, dataList(args), traceText(result))
+}
+
+func (t *TextTracer) OnError(err error) {
+	fmt.Fprintln(t.w, err)
+}
+
+func (t *TextTracer) OnToken(r Range, token Token, str string) {
+	fmt.Fprintf(t.w, "token %s\t%s\t%s\n", r.String(), token, str)
+}
+
+// traceText shows constants as Disassemble does and collections as
+// Data.String does. A lazy value shows only its Go type, as <type>, since
+// printing it would read every field and call every valuer.
+func traceText(d *Data) string {
+	if d == nil {
+		return constText(d, false)
+	}
+	switch d.Type {
+	case DTypeArray, DTypeObject:
+		return d.String()
+	case DTypeReflect:
+		return "<" + d.Value.(*DataReflect).value.Type().String() + ">"
+	}
+	return constText(d, false)
+}
+
+func dataList(ds []*Data) string {
+	items := make([]string, len(ds))
+	for i, d := range ds {
+		items[i] = traceText(d)
+	}
+	return strings.Join(items, ", ")
+}
+
+// SlogTracer logs every event to Logger at Level, which NewSlogTracer sets to
+// slog.LevelDebug.
+type SlogTracer struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+func NewSlogTracer(logger *slog.Logger) *SlogTracer {
+	return &SlogTracer{Logger: logger, Level: slog.LevelDebug}
+}
+
+func (t *SlogTracer) log(msg string, attrs func() []slog.Attr) {
+	ctx := context.Background()
+	if !t.Logger.Enabled(ctx, t.Level) {
+		return
+	}
+	t.Logger.LogAttrs(ctx, t.Level, msg, attrs()...)
+}
+
+func (t *SlogTracer) OnInstruction(pc int, op Opcode, stack []*Data) {
+	t.log("instruction", func() []slog.Attr {
+		return []slog.Attr{slog.Int("pc", pc), slog.String("op", mnemonic(int(op))), slog.String("stack", dataList(stack))}
+	})
+}
+
+func (t *SlogTracer) OnStatic(name string, value *Data, dur time.Duration) {
+	t.log("static", func() []slog.Attr {
+		return []slog.Attr{slog.String("name", name), slog.String("value", traceText(value)), slog.Duration("duration", dur)}
+	})
+}
+
+func (t *SlogTracer) OnCall(fn string, args []*Data, result *Data) {
+	t.log("call", func() []slog.Attr {
+		return []slog.Attr{slog.String("fn", fn), slog.String("args", dataList(args)), slog.String("result", traceText(result))}
+	})
+}
+
+func (t *SlogTracer) OnError(err error) {
+	t.log("error", func() []slog.Attr {
+		return []slog.Attr{slog.Any("error", err)}
+	})
+}
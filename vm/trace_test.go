@@ -0,0 +1,54 @@
+package smanchai
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+type countingValuer struct{ calls *int }
+
+func (c countingValuer) SmanchaiValue() (*Data, error) {
+	*c.calls++
+	return NewString("expensive"), nil
+}
+
+type tracedUser struct {
+	Name    string
+	Profile countingValuer
+}
+
+func TestTracerOption(t *testing.T) {
+	calls := 0
+	user, err := Lazy(&tracedUser{Name: "bob", Profile: countingValuer{&calls}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnv()
+	env.Set("user", StaticValue(user))
+	p := compile(t, `@user.Name == "bob"`)
+
+	var b bytes.Buffer
+	r, err := p.Run(context.Background(), env, WithTracer(NewTextTracer(&b)))
+	if err != nil || !r.Equal(NewBool(true)) {
+		t.Fatalf("got %s, %v", r, err)
+	}
+	out := b.String()
+	for _, want := range []string{"static @user = <smanchai.tracedUser>", "getattr", `"bob"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("trace is missing %q:\n%s", want, out)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("tracing called the valuer of an unread field %d times", calls)
+	}
+
+	b.Reset()
+	if _, err := p.Run(context.Background(), env); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("a run without WithTracer traced:\n%s", b.String())
+	}
+}
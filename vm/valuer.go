@@ -0,0 +1,77 @@
+package smanchai
+
+import (
+	"reflect"
+	"sync"
+)
+
+// SmanchaiValuer is implemented by host types that choose how they appear to
+// rules. Reflect and Lazy call it before falling back to kind-based conversion.
+type SmanchaiValuer interface {
+	SmanchaiValue() (*Data, error)
+}
+
+// Converter converts a value of the type it is registered for.
+type Converter func(v any) (*Data, error)
+
+var (
+	convertersMu sync.RWMutex
+	converters   = map[reflect.Type]Converter{}
+)
+
+// RegisterConverter makes every Reflector convert values of type typ with fn,
+// for third-party types that cannot implement SmanchaiValuer. Converters in
+// Reflector.Converters take precedence.
+func RegisterConverter(typ reflect.Type, fn Converter) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	if fn == nil {
+		delete(converters, typ)
+		return
+	}
+	converters[typ] = fn
+}
+
+var valuerType = reflect.TypeOf((*SmanchaiValuer)(nil)).Elem()
+
+func (r *Reflector) converter(typ reflect.Type) Converter {
+	if fn, o := r.Converters[typ]; o {
+		return fn
+	}
+	convertersMu.RLock()
+	defer convertersMu.RUnlock()
+	return converters[typ]
+}
+
+// custom converts data with a registered Converter or its SmanchaiValuer
+// implementation, reporting false when neither applies. Values read from
+// unexported fields are left to kind-based conversion, since their methods
+// cannot be called without bypassing Go's export rules.
+func (r *Reflector) custom(data reflect.Value) (*Data, bool, error) {
+	switch data.Kind() {
+	case reflect.Pointer:
+		if data.IsNil() {
+			return nil, false, nil
+		}
+	case reflect.Interface:
+		if data.IsNil() {
+			return nil, true, nil
+		}
+	}
+	if !data.CanInterface() {
+		return nil, false, nil
+	}
+	if fn := r.converter(data.Type()); fn != nil {
+		d, err := fn(data.Interface())
+		return d, true, err
+	}
+	if data.Type().Implements(valuerType) {
+		d, err := data.Interface().(SmanchaiValuer).SmanchaiValue()
+		return d, true, err
+	}
+	if data.Kind() != reflect.Pointer && data.CanAddr() && reflect.PointerTo(data.Type()).Implements(valuerType) {
+		d, err := data.Addr().Interface().(SmanchaiValuer).SmanchaiValue()
+		return d, true, err
+	}
+	return nil, false, nil
+}
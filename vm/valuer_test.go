@@ -0,0 +1,63 @@
+package smanchai
+
+import (
+	"context"
+	"testing"
+)
+
+type secret struct{ Code string }
+
+func (s secret) SmanchaiValue() (*Data, error) {
+	return &Data{Type: DTypeString, Value: "hidden"}, nil
+}
+
+type valuerHolder struct {
+	X SmanchaiValuer
+	Y SmanchaiValuer
+	z secret
+}
+
+func TestValuerNilInterface(t *testing.T) {
+	h := valuerHolder{Y: secret{Code: "1"}}
+	d, err := Reflect(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := d.Get("X"); x != nil {
+		t.Errorf("X = %s, want undefined", x)
+	}
+	if y := d.Get("Y"); y.String() != "hidden" {
+		t.Errorf("Y = %s, want hidden", y)
+	}
+	_ = d.String()
+
+	lazy, err := Lazy(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = lazy.String()
+	env := NewEnv()
+	env.Set("h", StaticValue(lazy))
+	r, err := compile(t, `@h.X`).Run(context.Background(), env)
+	if err != nil || r != nil {
+		t.Errorf("@h.X = %v, %v, want undefined", r, err)
+	}
+}
+
+func TestValuerUnexportedField(t *testing.T) {
+	// a valuer in an unexported field is converted by kind, never called
+	d, err := Reflect(valuerHolder{z: secret{Code: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z := d.Get("z"); z != nil && z.String() == "hidden" {
+		t.Errorf("z = %s, SmanchaiValue was called on an unexported field", z)
+	}
+	lazy, err := (&Reflector{}).Lazy(valuerHolder{z: secret{Code: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, _ := lazy.Value.(*DataReflect).Attr("z"); z != nil && z.String() == "hidden" {
+		t.Errorf("z = %s, SmanchaiValue was called on an unexported field", z)
+	}
+}
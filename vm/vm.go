@@ -1,11 +1,14 @@
 package smanchai
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Opcode int
@@ -15,9 +18,9 @@ const (
 	Op_getstatic
 	Op_getattr
 	Op_invoke    // invoke built-in or extended function
-	Op_loadrange // load Token range for good error
+	Op_loadrange // unused, positions come from the pc to Range table of Program
 	Op_iload     // load int from const to stack
-	Op_iinc      // increase int at index <x> by <y>
+	Op_iinc      // increase int on top of the stack by <x>
 	Op_iadd      //
 	Op_isub      //
 	Op_imul      //
@@ -30,7 +33,7 @@ const (
 	Op_i2d       // int to double
 
 	Op_dload //
-	Op_dinc  //
+	Op_dinc  // increase double on top of the stack by <x>
 	Op_dadd  //
 	Op_dsub  //
 	Op_dmul  //
@@ -46,12 +49,28 @@ const (
 	Op_cmp_ge  // compare greater eq
 	Op_cmp_l   // compare less
 	Op_cmp_le  // compare less eq
+
+	Op_mload // load decimal from const to stack
+	Op_madd  //
+	Op_msub  //
+	Op_mmul  //
+	Op_mdiv  // rounded to Options.DecimalScale with Options.DecimalRounding
+	Op_mmod  //
+	Op_mexp  // integral exponents only
+	Op_i2m   // int to decimal
+	Op_d2m   // double to decimal
+	Op_m2i   // decimal to int, failing when inexact like Convert
+	Op_m2d   // decimal to double, failing when inexact
+	Op_conv  // convert to dataType <x>, see Convert
+	Op_band  // logical and, see VM.truthy
+	Op_bor   // logical or, see VM.truthy
 )
 
 var opcodes = []string{
 	Op_getstatic: "Op_getstatic",
 	Op_getattr:   "Op_getattr",
 	Op_invoke:    "Op_invoke",
+	Op_loadrange: "Op_loadrange",
 	Op_iload:     "Op_iload",
 	Op_iinc:      "Op_iinc",
 	Op_iadd:      "Op_iadd",
@@ -80,22 +99,41 @@ var opcodes = []string{
 	Op_cmp_ge:    "Op_cmp_ge",
 	Op_cmp_l:     "Op_cmp_l",
 	Op_cmp_le:    "Op_cmp_le",
+	Op_mload:     "Op_mload",
+	Op_madd:      "Op_madd",
+	Op_msub:      "Op_msub",
+	Op_mmul:      "Op_mmul",
+	Op_mdiv:      "Op_mdiv",
+	Op_mmod:      "Op_mmod",
+	Op_mexp:      "Op_mexp",
+	Op_i2m:       "Op_i2m",
+	Op_d2m:       "Op_d2m",
+	Op_m2i:       "Op_m2i",
+	Op_m2d:       "Op_m2d",
+	Op_conv:      "Op_conv",
+	Op_band:      "Op_band",
+	Op_bor:       "Op_bor",
 }
 
 func (op Opcode) String() string {
+	if op < 0 || int(op) >= len(opcodes) || opcodes[op] == "" {
+		return fmt.Sprintf("Opcode(%d)", int(op))
+	}
 	return opcodes[op]
 }
 
-type emittedType int
-
-const (
-	E_Inst = iota
-	E_Const
-)
-
-type emitted struct {
-	Type  emittedType
-	Value any
+// operands is the number of operands that follow an opcode in Program.insts.
+var operands = map[int]int{
+	Op_getstatic: 1,
+	Op_getattr:   1,
+	Op_invoke:    1,
+	Op_iload:     1,
+	Op_iinc:      1,
+	Op_dload:     1,
+	Op_dinc:      1,
+	Op_sload:     1,
+	Op_mload:     1,
+	Op_conv:      1,
 }
 
 type dataType int
@@ -112,6 +150,8 @@ const (
 	DTypeAttrRef
 	DTypeMethodRef
 	DTypeObject
+	DTypeDecimal
+	DTypeReflect // lazily converted Go value, see DataReflect
 )
 
 type DataRefType int
@@ -143,10 +183,46 @@ type DataObjectArray struct {
 
 type DataObjectMap struct {
 	Data map[string]*Data
+	Keys []string // attribute order when known, e.g. from JSON
+}
+
+// OrderedKeys returns the attribute names in Keys order followed by any
+// attributes missing from Keys in sorted order.
+func (m *DataObjectMap) OrderedKeys() []string {
+	keys := make([]string, 0, len(m.Data))
+	seen := make(map[string]bool, len(m.Keys))
+	for _, k := range m.Keys {
+		if _, o := m.Data[k]; o && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	rest := make([]string, 0, len(m.Data)-len(keys))
+	for k := range m.Data {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
 }
 
 func (t *Data) String() string {
-	return fmt.Sprintf("%s", t.Value)
+	if t == nil {
+		return "undefined"
+	}
+	switch t.Type {
+	case DTypeArray, DTypeObject, DTypeReflect:
+		var b strings.Builder
+		formatData(&b, t, "", 0)
+		return b.String()
+	case DTypeBool:
+		return strconv.FormatBool(boolValue(t.Value))
+	case DTypeChar:
+		c, _ := intValue(t.Value)
+		return string(rune(c))
+	}
+	return fmt.Sprintf("%v", t.Value)
 }
 
 type stack[T any] struct {
@@ -160,7 +236,7 @@ func (s *stack[T]) Push(v T) {
 func (s *stack[T]) Pop() T {
 	l := len(s.arr)
 	if l == 0 {
-		panic("stack no item left to pop")
+		panic(newError(StackUnderflow, "error: operand stack underflow"))
 	}
 	v := s.arr[l-1]
 	s.arr = s.arr[:l-1]
@@ -176,320 +252,545 @@ func (s *stack[T]) Len() int {
 	return len(s.arr)
 }
 
-type static func() *Data
-
+// VM is the state of a single Program.Run. Everything a run changes lives
+// here, never in the Program.
 type VM struct {
-	static     map[string]static
-	ConstsPool []*Data
-	Insts      []int
-	pc         int // program counter
-	Dg         bool
+	*Program
+	ctx     context.Context
+	env     *Env
+	tracer  Tracer
+	pc      int // program counter
+	at      int // pc of the instruction being executed
+	steps   int // instructions executed
+	operand stack[*Data]
 }
 
-func (vm *VM) AddStatic(name string, ex static) {
-	vm.static[name] = ex
+// cancelEvery is how many instructions run between checks of VM.ctx.
+const cancelEvery = 256
+
+func (vm *VM) run() (result *Data, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, panicError(r)
+		}
+	}()
+	for vm.pc < len(vm.insts) {
+		if err := vm.step(); err != nil {
+			return nil, err
+		}
+	}
+	return vm.result()
 }
 
-func (vm *VM) Run() (*Data, error) {
-	operand := stack[*Data]{arr: make([]*Data, 0, 256)}
-	if vm.Dg {
-		fmt.Println("run vm")
+// step executes the instruction at vm.pc.
+func (vm *VM) step() error {
+	operand := &vm.operand
+	inst := vm.insts[vm.pc]
+	vm.at = vm.pc
+	if inst <= Op || inst >= len(opcodes) || opcodes[inst] == "" {
+		return newError(InternalError, "error: unknown opcode %d", inst)
 	}
-	for {
-		if vm.pc >= len(vm.Insts) {
-			break
+	if vm.pc+operands[inst] >= len(vm.insts) {
+		return newError(InternalError, "error: missing operand of %s", Opcode(inst))
+	}
+	if constOperand(inst) {
+		i := vm.insts[vm.pc+1]
+		if i < 0 || i >= len(vm.consts) {
+			return newError(InternalError, "error: constant %d of %s out of range", i, Opcode(inst))
 		}
-		inst := vm.Insts[vm.pc]
-		if vm.Dg {
-			fmt.Printf("Rx:\t%d:%d\t%s\n", vm.pc, inst, opcodes[inst])
-			{
-				b, err := json.Marshal(operand.arr)
-				if err != nil {
-					fmt.Println(err)
-				} else {
-					fmt.Println(string(b))
-				}
-			}
+		if vm.consts[i] == nil {
+			return badConst(inst, nil)
 		}
-		switch inst {
-		case Op_getstatic:
-			vm.pc++
-			b0 := vm.ConstsPool[vm.Insts[vm.pc]]
-			switch b0.Type {
-			case DTypeDataRef:
-				b1 := b0.Value.(*DataRef)
-				switch b1.Root {
-				case DRTypeVMStatic:
-					if v, o := vm.static[b1.Name]; o {
-						operand.Push(v())
-					} else {
-						return nil, fmt.Errorf("error: not found static named \"%s\"", b1.Name)
+	}
+	if vm.steps%cancelEvery == 0 {
+		if err := vm.canceled(); err != nil {
+			return err
+		}
+	}
+	vm.steps++
+	if max := vm.options.Limits.Instructions; max > 0 && vm.steps > max {
+		return limitError(ErrInstructionLimit, max)
+	}
+	if vm.tracer != nil {
+		vm.tracer.OnInstruction(vm.at, Opcode(inst), operand.arr)
+	}
+	switch inst {
+	case Op_getstatic:
+		vm.pc++
+		b0 := vm.consts[vm.insts[vm.pc]]
+		switch b0.Type {
+		case DTypeDataRef:
+			b1 := b0.Value.(*DataRef)
+			switch b1.Root {
+			case DRTypeVMStatic:
+				if v, o := vm.env.Lookup(b1.Name); o {
+					var start time.Time
+					if vm.tracer != nil {
+						start = time.Now()
 					}
-				default:
-					return nil, fmt.Errorf("unknown error: stack error")
-				}
-			default:
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-		case Op_getattr:
-			vm.pc++
-			b0 := vm.ConstsPool[vm.Insts[vm.pc]]
-			switch b0.Type {
-			case DTypeAttrRef:
-				b1 := b0.Value.(*AttrRef)
-				b2 := operand.Pop()
-				switch b2.Type {
-				case DTypeArray:
-					i, err := strconv.Atoi(b1.Name)
+					d, err := vm.readStatic(b1.Name, v)
 					if err != nil {
-						return nil, err
+						return err
+					}
+					if vm.tracer != nil {
+						vm.tracer.OnStatic(b1.Name, d, time.Since(start))
+					}
+					if err := vm.canceled(); err != nil {
+						return err
 					}
-					b3 := b2.Value.(*DataObjectArray)
-					operand.Push(b3.Data[i])
-				case DTypeObject:
-					b3 := b2.Value.(*DataObjectMap)
-					operand.Push(b3.Data[b1.Name])
-				default:
-					return nil, fmt.Errorf("unknown error: wrong data type of getattr operand")
+					if err := vm.checkSize(d); err != nil {
+						return err
+					}
+					operand.Push(d)
+				} else {
+					return newError(UnknownStatic, "error: not found static named \"%s\"", b1.Name)
 				}
 			default:
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-		case Op_invoke:
-			vm.pc++
-			b0 := vm.ConstsPool[vm.Insts[vm.pc]]
-			switch b0.Type {
-			case DTypeMethodRef:
-			default:
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-		case Op_iload:
-			vm.pc++
-			b0 := vm.ConstsPool[vm.Insts[vm.pc]]
-			if b0.Type == DTypeInt || b0.Type == DTypeBool || b0.Type == DTypeChar {
-				operand.Push(b0)
-			} else {
-				return nil, fmt.Errorf("unknown error: stack error")
+				return newError(InternalError, "error: unsupported static root %d", b1.Root)
 			}
-		case Op_iinc:
-			vm.pc++
-			a0 := vm.Insts[vm.pc]
-			a1 := vm.Insts[vm.pc]
-			b0 := vm.ConstsPool[a0]
-			if b0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-			b0.Value = b0.Value.(int) + a1
-		case Op_iadd, Op_isub, Op_imul, Op_idiv, Op_imod:
-			a0 := operand.Pop()
-			a1 := operand.Pop()
-			if a0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-			if a1.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
+		default:
+			return badConst(inst, b0)
+		}
+	case Op_getattr:
+		vm.pc++
+		b0 := vm.consts[vm.insts[vm.pc]]
+		switch b0.Type {
+		case DTypeAttrRef:
+			b1 := b0.Value.(*AttrRef)
+			b2 := operand.Pop()
+			if b2 == nil {
+				return newError(MissingAttribute, "error: cannot read attribute \"%s\" of undefined", b1.Name)
 			}
-			switch inst {
-			case Op_iadd:
-				a0.Value = a1.Value.(int) + a0.Value.(int)
-			case Op_isub:
-				a0.Value = a1.Value.(int) - a0.Value.(int)
-			case Op_imul:
-				a0.Value = a1.Value.(int) * a0.Value.(int)
-			case Op_idiv:
-				a0.Value = a1.Value.(int) / a0.Value.(int)
-			case Op_imod:
-				a0.Value = a1.Value.(int) % a0.Value.(int)
+			switch b2.Type {
+			case DTypeArray:
+				b3 := b2.Value.(*DataObjectArray)
+				i, err := strconv.Atoi(b1.Name)
+				if err != nil || i < 0 || i >= len(b3.Data) {
+					return newError(IndexOutOfRange, "error: index \"%s\" out of range [0:%d]", b1.Name, len(b3.Data))
+				}
+				if err := vm.checkSize(b3.Data[i]); err != nil {
+					return err
+				}
+				operand.Push(b3.Data[i])
+			case DTypeObject:
+				b3 := b2.Value.(*DataObjectMap)
+				if err := vm.checkSize(b3.Data[b1.Name]); err != nil {
+					return err
+				}
+				operand.Push(b3.Data[b1.Name])
+			case DTypeReflect:
+				b3, err := b2.Value.(*DataReflect).Attr(b1.Name)
+				if err != nil {
+					return withKind(HostFunctionFailed, err)
+				}
+				if err := vm.checkSize(b3); err != nil {
+					return err
+				}
+				operand.Push(b3)
+			default:
+				return newError(TypeMismatch, "error: cannot read attribute \"%s\" of %s", b1.Name, typeName(b2))
 			}
-			operand.Push(a0)
-		case Op_iand, Op_ior:
-			a0 := operand.Pop()
-			a1 := operand.Pop()
-			if a0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
+		default:
+			return badConst(inst, b0)
+		}
+	case Op_invoke:
+		vm.pc++
+		b0 := vm.consts[vm.insts[vm.pc]]
+		switch b0.Type {
+		case DTypeMethodRef:
+			b1 := b0.Value.(*MethodRef)
+			args := make([]*Data, b1.Argc)
+			for i := b1.Argc - 1; i >= 0; i-- {
+				args[i] = operand.Pop()
 			}
-			if a1.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
+			r, err := vm.invokeMethod(operand.Pop(), b1, args)
+			if err != nil {
+				return err
 			}
-			switch inst {
-			case Op_iand:
-				operand.Push(&Data{
-					Type:  DTypeInt,
-					Value: a0.Value.(int) & a1.Value.(int),
-				})
-			case Op_ior:
-				operand.Push(&Data{
-					Type:  DTypeInt,
-					Value: a0.Value.(int) | a1.Value.(int),
-				})
+			if vm.tracer != nil {
+				vm.tracer.OnCall(b1.Name, args, r)
 			}
-			// TODO
-		case Op_i2b:
-			a0 := operand.Pop()
-			if a0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
+			if err := vm.canceled(); err != nil {
+				return err
 			}
-			m := a0.Value.(int)
-			if m > 0 {
-				m = 1
-			} else {
-				m = 0
+			if err := vm.checkSize(r); err != nil {
+				return err
 			}
+			operand.Push(r)
+		default:
+			return badConst(inst, b0)
+		}
+	case Op_iload:
+		vm.pc++
+		b0 := vm.consts[vm.insts[vm.pc]]
+		if b0.Type == DTypeInt || b0.Type == DTypeBool || b0.Type == DTypeChar {
+			operand.Push(b0)
+		} else {
+			return badConst(inst, b0)
+		}
+	case Op_iinc:
+		vm.pc++
+		a0 := operand.Pop()
+		if a0 == nil || a0.Type != DTypeInt {
+			return operandError(inst, a0)
+		}
+		x, _ := intValue(a0.Value)
+		operand.Push(&Data{
+			Type:  DTypeInt,
+			Value: int(x) + vm.insts[vm.pc],
+		})
+	case Op_iadd, Op_isub, Op_imul, Op_idiv, Op_imod:
+		a0 := operand.Pop()
+		a1 := operand.Pop()
+		if a0 == nil || a0.Type != DTypeInt || a1 == nil || a1.Type != DTypeInt {
+			return operandError(inst, a1, a0)
+		}
+		x, _ := intValue(a1.Value)
+		y, _ := intValue(a0.Value)
+		if y == 0 && (inst == Op_idiv || inst == Op_imod) {
+			return newError(DivisionByZero, "error: integer division by zero")
+		}
+		var v int64
+		switch inst {
+		case Op_iadd:
+			v = x + y
+		case Op_isub:
+			v = x - y
+		case Op_imul:
+			v = x * y
+		case Op_idiv:
+			v = x / y
+		case Op_imod:
+			v = x % y
+		}
+		operand.Push(&Data{
+			Type:  DTypeInt,
+			Value: int(v),
+		})
+	case Op_iand, Op_ior:
+		a0 := operand.Pop()
+		a1 := operand.Pop()
+		if a0 == nil || a0.Type != DTypeInt || a1 == nil || a1.Type != DTypeInt {
+			return operandError(inst, a1, a0)
+		}
+		x, _ := intValue(a1.Value)
+		y, _ := intValue(a0.Value)
+		switch inst {
+		case Op_iand:
 			operand.Push(&Data{
-				Type:  DTypeBool,
-				Value: m,
+				Type:  DTypeInt,
+				Value: int(x & y),
 			})
-		case Op_i2c:
-			a0 := operand.Pop()
-			if a0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
+		case Op_ior:
 			operand.Push(&Data{
-				Type:  DTypeChar,
-				Value: byte(a0.Value.(int)),
+				Type:  DTypeInt,
+				Value: int(x | y),
 			})
+		}
+	case Op_band, Op_bor:
+		a0 := operand.Pop()
+		a1 := operand.Pop()
+		x, err := vm.truthy(a1)
+		if err != nil {
+			return err
+		}
+		y, err := vm.truthy(a0)
+		if err != nil {
+			return err
+		}
+		switch inst {
+		case Op_band:
+			operand.Push(newBool(x && y))
+		case Op_bor:
+			operand.Push(newBool(x || y))
+		}
+	case Op_i2b, Op_i2c, Op_i2d:
+		a0 := operand.Pop()
+		if a0 == nil || a0.Type != DTypeInt {
+			return operandError(inst, a0)
+		}
+		to := DTypeBool
+		switch inst {
+		case Op_i2c:
+			to = DTypeChar
 		case Op_i2d:
-			a0 := operand.Pop()
-			if a0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-			operand.Push(&Data{
-				Type:  DTypeChar,
-				Value: float64(a0.Value.(int)),
-			})
-		case Op_dload:
-			vm.pc++
-			b0 := vm.ConstsPool[vm.Insts[vm.pc]]
-			if b0.Type == DTypeDouble {
-				operand.Push(b0)
-			} else {
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-		case Op_dinc:
-			vm.pc++
-			a0 := vm.Insts[vm.pc]
-			a1 := vm.Insts[vm.pc]
-			b0 := vm.ConstsPool[a0]
-			if b0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-			b0.Value = b0.Value.(float64) + float64(a1)
-		case Op_dadd, Op_dsub, Op_dmul, Op_ddiv, Op_dmod, Op_dexp:
-			a0 := operand.Pop()
-			a1 := operand.Pop()
-			if a0.Type != DTypeDouble && a0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-			if a1.Type != DTypeDouble && a0.Type != DTypeInt {
-				return nil, fmt.Errorf("unknown error: stack error")
-			}
-			switch inst {
-			case Op_dadd:
-				a0.Value = a1.Value.(float64) + a0.Value.(float64)
-			case Op_dsub:
-				a0.Value = a1.Value.(float64) - a0.Value.(float64)
-			case Op_dmul:
-				a0.Value = a1.Value.(float64) * a0.Value.(float64)
-			case Op_ddiv:
-				a0.Value = a1.Value.(float64) / a0.Value.(float64)
-			case Op_dmod:
-				a0.Value = a1.Value.(int) % a0.Value.(int)
-			case Op_dexp:
-				a0.Value = math.Pow(a1.Value.(float64), a0.Value.(float64))
-			}
-			operand.Push(a0)
-		case Op_sload:
-			vm.pc++
-			b0 := vm.ConstsPool[vm.Insts[vm.pc]]
-			if b0.Type == DTypeString {
-				operand.Push(b0)
-			} else {
-				return nil, fmt.Errorf("unknown error: string")
-			}
-		case Op_sconcat:
-			a0 := operand.Pop()
-			a1 := operand.Pop()
-			if a0 != nil && a0.Type != DTypeString {
-				return nil, fmt.Errorf("unknown error: you cannot concat string with other type")
-			}
-			if a1 != nil && a1.Type != DTypeString {
-				return nil, fmt.Errorf("unknown error: you cannot concat string with other type")
-			}
-			var a, b string
-			if a0 == nil {
-				a = "undefined"
-			} else {
-				a = a0.Value.(string)
-			}
-			if a1 == nil {
-				b = "undefined"
-			} else {
-				b = a1.Value.(string)
-			}
-			a0.Value = b + a
-			operand.Push(a0)
-		case Op_cmp_eq, Op_cmp_ne, Op_cmp_g, Op_cmp_ge, Op_cmp_l, Op_cmp_le:
-			a0 := operand.Pop()
-			a1 := operand.Pop()
-			var v int = 0
-			switch inst {
-			case Op_cmp_eq:
-				if a0.Value == a1.Value {
-					v = 1
-				}
-			case Op_cmp_ne:
-				if a0.Value != a1.Value {
-					v = 1
-				}
-			case Op_cmp_g:
-				if (a0.Type == DTypeInt || a0.Type == DTypeChar || a0.Type == DTypeDouble || a0.Type == DTypeBool) &&
-					(a1.Type == DTypeInt || a1.Type == DTypeChar || a1.Type == DTypeDouble || a1.Type == DTypeBool) {
-					if toFloat64(a1.Value) > toFloat64(a0.Value) {
-						v = 1
-					}
-				}
-			case Op_cmp_ge:
-				if (a0.Type == DTypeInt || a0.Type == DTypeChar || a0.Type == DTypeDouble || a0.Type == DTypeBool) &&
-					(a1.Type == DTypeInt || a1.Type == DTypeChar || a1.Type == DTypeDouble || a1.Type == DTypeBool) {
-					if toFloat64(a1.Value) >= toFloat64(a0.Value) {
-						v = 1
-					}
-				}
-			case Op_cmp_l:
-				if (a0.Type == DTypeInt || a0.Type == DTypeChar || a0.Type == DTypeDouble || a0.Type == DTypeBool) &&
-					(a1.Type == DTypeInt || a1.Type == DTypeChar || a1.Type == DTypeDouble || a1.Type == DTypeBool) {
-					if toFloat64(a1.Value) < toFloat64(a0.Value) {
-						v = 1
-					}
-				}
-			case Op_cmp_le:
-				if (a0.Type == DTypeInt || a0.Type == DTypeChar || a0.Type == DTypeDouble || a0.Type == DTypeBool) &&
-					(a1.Type == DTypeInt || a1.Type == DTypeChar || a1.Type == DTypeDouble || a1.Type == DTypeBool) {
-					if toFloat64(a1.Value) <= toFloat64(a0.Value) {
-						v = 1
-					}
-				}
+			to = DTypeDouble
+		}
+		r, err := Convert(a0, dataType(to))
+		if err != nil {
+			return err
+		}
+		operand.Push(r)
+	case Op_conv:
+		vm.pc++
+		r, err := Convert(operand.Pop(), dataType(vm.insts[vm.pc]))
+		if err != nil {
+			return err
+		}
+		operand.Push(r)
+	case Op_dload:
+		vm.pc++
+		b0 := vm.consts[vm.insts[vm.pc]]
+		if b0.Type == DTypeDouble {
+			operand.Push(b0)
+		} else {
+			return badConst(inst, b0)
+		}
+	case Op_dinc:
+		vm.pc++
+		a0 := operand.Pop()
+		if a0 == nil || a0.Type != DTypeDouble {
+			return operandError(inst, a0)
+		}
+		operand.Push(&Data{
+			Type:  DTypeDouble,
+			Value: toFloat64(a0.Value) + float64(vm.insts[vm.pc]),
+		})
+	case Op_dadd, Op_dsub, Op_dmul, Op_ddiv, Op_dmod, Op_dexp:
+		a0 := operand.Pop()
+		a1 := operand.Pop()
+		if a0 == nil || a1 == nil {
+			return operandError(inst, a1, a0)
+		}
+		if a0.Type == DTypeDecimal || a1.Type == DTypeDecimal {
+			r, err := vm.decimalArith(inst, a1, a0)
+			if err != nil {
+				return err
 			}
-			operand.Push(&Data{
-				Type:  DTypeInt,
-				Value: v,
-			})
+			operand.Push(r)
+			break
+		}
+		if (a0.Type != DTypeDouble && a0.Type != DTypeInt) || (a1.Type != DTypeDouble && a1.Type != DTypeInt) {
+			return operandError(inst, a1, a0)
 		}
+		x, y := toFloat64(a1.Value), toFloat64(a0.Value)
+		var v float64
+		switch inst {
+		case Op_dadd:
+			v = x + y
+		case Op_dsub:
+			v = x - y
+		case Op_dmul:
+			v = x * y
+		case Op_ddiv:
+			v = x / y
+		case Op_dmod:
+			v = math.Mod(x, y)
+		case Op_dexp:
+			v = math.Pow(x, y)
+		}
+		operand.Push(&Data{
+			Type:  DTypeDouble,
+			Value: v,
+		})
+	case Op_sload:
 		vm.pc++
+		b0 := vm.consts[vm.insts[vm.pc]]
+		if b0.Type == DTypeString {
+			operand.Push(b0)
+		} else {
+			return badConst(inst, b0)
+		}
+	case Op_sconcat:
+		a0 := operand.Pop()
+		a1 := operand.Pop()
+		if (a0 != nil && a0.Type != DTypeString) || (a1 != nil && a1.Type != DTypeString) {
+			return operandError(inst, a1, a0)
+		}
+		var a, b string
+		if a0 == nil {
+			a = "undefined"
+		} else {
+			a = a0.Value.(string)
+		}
+		if a1 == nil {
+			b = "undefined"
+		} else {
+			b = a1.Value.(string)
+		}
+		if max := vm.options.Limits.StringLength; max > 0 && len(a)+len(b) > max {
+			return limitError(ErrStringLimit, max)
+		}
+		operand.Push(&Data{
+			Type:  DTypeString,
+			Value: b + a,
+		})
+	case Op_cmp_eq, Op_cmp_ne, Op_cmp_g, Op_cmp_ge, Op_cmp_l, Op_cmp_le:
+		a0, err := eager(operand.Pop())
+		if err != nil {
+			return withKind(HostFunctionFailed, err)
+		}
+		a1, err := eager(operand.Pop())
+		if err != nil {
+			return withKind(HostFunctionFailed, err)
+		}
+		r, err := vm.compare(inst, a1, a0)
+		if err != nil {
+			return err
+		}
+		operand.Push(r)
+	case Op_mload:
+		vm.pc++
+		b0 := vm.consts[vm.insts[vm.pc]]
+		if b0.Type == DTypeDecimal {
+			operand.Push(b0)
+		} else {
+			return badConst(inst, b0)
+		}
+	case Op_madd, Op_msub, Op_mmul, Op_mdiv, Op_mmod, Op_mexp:
+		a0 := operand.Pop()
+		a1 := operand.Pop()
+		r, err := vm.decimalArith(inst, a1, a0)
+		if err != nil {
+			return err
+		}
+		operand.Push(r)
+	case Op_i2m, Op_d2m:
+		a0 := operand.Pop()
+		if a0 == nil || (inst == Op_i2m && a0.Type != DTypeInt) || (inst == Op_d2m && a0.Type != DTypeDouble) {
+			return operandError(inst, a0)
+		}
+		m, err := toDecimal(a0)
+		if err != nil {
+			return err
+		}
+		operand.Push(&Data{
+			Type:  DTypeDecimal,
+			Value: m,
+		})
+	case Op_m2i, Op_m2d:
+		a0 := operand.Pop()
+		if a0 == nil || a0.Type != DTypeDecimal {
+			return operandError(inst, a0)
+		}
+		to := DTypeDouble
+		if inst == Op_m2i {
+			to = DTypeInt
+		}
+		r, err := Convert(a0, dataType(to))
+		if err != nil {
+			return err
+		}
+		operand.Push(r)
+	}
+	if max := vm.options.Limits.StackDepth; max > 0 && operand.Len() > max {
+		return limitError(ErrStackLimit, max)
 	}
+	vm.pc++
+	return nil
+}
+
+// result is the value left on the stack by a finished run.
+func (vm *VM) result() (*Data, error) {
+	operand := &vm.operand
 	if operand.Len() > 0 {
-		result := operand.Pop()
-		if vm.Dg {
-			fmt.Printf("Result %s\n", result)
+		result, err := eager(operand.Pop())
+		if err != nil {
+			return nil, withKind(HostFunctionFailed, err)
+		}
+		if result != nil {
+			// the top of the stack may be a constant, which callers must not reach
+			r := *result
+			result = &r
 		}
 		return result, nil
 	}
 	return nil, nil
 }
 
+// canceled returns a Canceled RuntimeError once the context of the run is done.
+func (vm *VM) canceled() error {
+	if err := vm.ctx.Err(); err != nil {
+		return &RuntimeError{Kind: Canceled, Err: fmt.Errorf("error: %w", err)}
+	}
+	return nil
+}
+
+// readStatic calls the provider of the static name, naming the static in any
+// error it returns or panic it raises.
+func (vm *VM) readStatic(name string, s Static) (d *Data, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			d, err = nil, newError(HostFunctionFailed, "error: static \"%s\" panicked: %v", name, r)
+		}
+	}()
+	d, err = s(vm.ctx)
+	if err == nil {
+		return d, nil
+	}
+	kind := HostFunctionFailed
+	if vm.ctx.Err() != nil {
+		kind = Canceled
+	}
+	return nil, &RuntimeError{Kind: kind, Err: fmt.Errorf("error: static \"%s\" failed: %w", name, err)}
+}
+
+// checkSize enforces Limits.CollectionSize on a value read from the Env or a
+// method.
+func (vm *VM) checkSize(d *Data) error {
+	max := vm.options.Limits.CollectionSize
+	if max <= 0 || d == nil {
+		return nil
+	}
+	n := 0
+	switch d.Type {
+	case DTypeArray:
+		n = len(d.Value.(*DataObjectArray).Data)
+	case DTypeObject:
+		n = len(d.Value.(*DataObjectMap).Data)
+	case DTypeReflect:
+		n = d.Value.(*DataReflect).Len()
+	}
+	if n > max {
+		return limitError(ErrCollectionLimit, max)
+	}
+	return nil
+}
+
+// truthy reports whether d counts as true in and/or. false, undefined, zero
+// numbers, the empty string and empty arrays and objects are false; everything
+// else is true. With StrictBool set only bool operands are accepted.
+func (vm *VM) truthy(d *Data) (bool, error) {
+	if d != nil && d.Type == DTypeBool {
+		return boolValue(d.Value), nil
+	}
+	if vm.options.StrictBool {
+		if d == nil {
+			return false, newError(TypeMismatch, "error: expected bool operand, got undefined")
+		}
+		return false, newError(TypeMismatch, "error: expected bool operand, got %s %s", d.Type, quoteData(d))
+	}
+	if d == nil {
+		return false, nil
+	}
+	switch d.Type {
+	case DTypeInt, DTypeChar:
+		i, _ := intValue(d.Value)
+		return i != 0, nil
+	case DTypeDouble:
+		return toFloat64(d.Value) != 0, nil
+	case DTypeDecimal:
+		return d.Value.(Decimal).Sign() != 0, nil
+	case DTypeString:
+		return d.Value.(string) != "", nil
+	case DTypeArray:
+		return len(d.Value.(*DataObjectArray).Data) > 0, nil
+	case DTypeObject:
+		return len(d.Value.(*DataObjectMap).Data) > 0, nil
+	case DTypeReflect:
+		return d.Value.(*DataReflect).Len() > 0, nil
+	}
+	return true, nil
+}
+
 func toFloat64(value interface{}) float64 {
 	v := reflect.ValueOf(value)
 	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
 	case reflect.Float32, reflect.Float64:
 		return v.Float()
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
@@ -501,32 +802,71 @@ func toFloat64(value interface{}) float64 {
 	}
 }
 
-func TestVM() *VM {
-	consts := make([]*Data, 0)
-	insts := make([]int, 0)
-	consts = append(consts, &Data{
-		Type:  DTypeInt,
-		Value: 8,
-	})
-	consts = append(consts, &Data{
-		Type:  DTypeInt,
-		Value: 10,
-	})
-	consts = append(consts, &Data{
-		Type:  DTypeInt,
-		Value: 7,
-	})
-	insts = append(insts, Op_iload)
-	insts = append(insts, 2)
-	insts = append(insts, Op_iload)
-	insts = append(insts, 0)
-	insts = append(insts, Op_iload)
-	insts = append(insts, 1)
-	insts = append(insts, Op_imul)
-	insts = append(insts, Op_iadd)
-	return &VM{
-		ConstsPool: consts,
-		Insts:      insts,
-		pc:         0,
+// decimalArith evaluates a1 <op> a0 exactly, promoting int and double operands
+// to decimal. It serves both the Op_m* opcodes and Op_d* opcodes that meet a
+// decimal operand at runtime.
+func (vm *VM) decimalArith(inst int, a1 *Data, a0 *Data) (*Data, error) {
+	x, err := toDecimal(a1)
+	if err != nil {
+		return nil, err
+	}
+	y, err := toDecimal(a0)
+	if err != nil {
+		return nil, err
+	}
+	var r Decimal
+	switch inst {
+	case Op_madd, Op_dadd:
+		r = x.Add(y)
+	case Op_msub, Op_dsub:
+		r = x.Sub(y)
+	case Op_mmul, Op_dmul:
+		r = x.Mul(y)
+	case Op_mdiv, Op_ddiv:
+		r, err = x.Quo(y, vm.options.DecimalScale, vm.options.DecimalRounding)
+	case Op_mmod, Op_dmod:
+		r, err = x.Mod(y)
+	case Op_mexp, Op_dexp:
+		r, err = x.Pow(y, vm.options.DecimalScale, vm.options.DecimalRounding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Data{
+		Type:  DTypeDecimal,
+		Value: r,
+	}, nil
+}
+
+func toDecimal(d *Data) (Decimal, error) {
+	if d == nil {
+		return Decimal{}, newError(TypeMismatch, "error: cannot convert undefined to decimal")
+	}
+	switch d.Type {
+	case DTypeDecimal:
+		return d.Value.(Decimal), nil
+	case DTypeInt:
+		v := reflect.ValueOf(d.Value)
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return NewDecimalFromInt(v.Int()), nil
+		}
+	case DTypeDouble:
+		return NewDecimalFromFloat(toFloat64(d.Value))
+	}
+	return Decimal{}, newError(TypeMismatch, "error: cannot convert %v to decimal", d.Value)
+}
+
+func TestVM() *Program {
+	p, err := Assemble(`
+		iload 7
+		iload 8
+		iload 10
+		imul
+		iadd
+	`)
+	if err != nil {
+		panic(err)
 	}
+	return p
 }
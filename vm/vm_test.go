@@ -0,0 +1,163 @@
+package smanchai
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTruthy(t *testing.T) {
+	lazy := func(v any) *Data {
+		d, err := Lazy(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	obj := NewObject()
+	obj.Set("a", NewInt(1))
+	tests := []struct {
+		in   *Data
+		want bool
+	}{
+		{nil, false},
+		{NewBool(false), false},
+		{NewBool(true), true},
+		{NewInt(0), false},
+		{NewInt(-1), true},
+		{NewFloat(0), false},
+		{NewFloat(math.Copysign(0, -1)), false},
+		{NewFloat(0.5), true},
+		{NewFloat(math.NaN()), true},
+		{NewDecimal(MustParseDecimal("0.00")), false},
+		{NewDecimal(MustParseDecimal("0.01")), true},
+		{NewChar(0), false},
+		{NewChar('a'), true},
+		{NewString(""), false},
+		{NewString("false"), true},
+		{NewArray(), false},
+		{NewArray(nil), true},
+		{NewObject(), false},
+		{obj, true},
+		{lazy([]int{}), false},
+		{lazy([]int{0}), true},
+		{lazy(map[string]int{}), false},
+		{lazy(map[string]int{"a": 0}), true},
+	}
+	and, or := compile(t, `@x and true`), compile(t, `@x or false`)
+	for _, tt := range tests {
+		env := NewEnv()
+		env.Set("x", StaticValue(tt.in))
+		for _, p := range []*Program{and, or} {
+			r, err := p.Run(context.Background(), env)
+			if err != nil {
+				t.Errorf("%s: %v", tt.in, err)
+				continue
+			}
+			if r == nil || r.Type != DTypeBool || r.Value != tt.want {
+				t.Errorf("%s: got %s %s, want bool %v", tt.in, typeName(r), r, tt.want)
+			}
+		}
+	}
+}
+
+func TestStrictBool(t *testing.T) {
+	for _, src := range []string{`@x and true`, `true and @x`, `@x or false`, `false or @x`} {
+		p := compile(t, src).WithOptions(Options{StrictBool: true})
+		for _, x := range []*Data{nil, NewInt(1), NewFloat(0), NewString("true"), NewArray(NewBool(true))} {
+			env := NewEnv()
+			env.Set("x", StaticValue(x))
+			if r, err := p.Run(context.Background(), env); !errors.Is(err, TypeMismatch) {
+				t.Errorf("%s with %s: got %s, %v, want a TypeMismatch", src, x, r, err)
+			}
+		}
+		for _, x := range []bool{false, true} {
+			env := NewEnv()
+			env.Set("x", StaticValue(NewBool(x)))
+			r, err := p.Run(context.Background(), env)
+			if err != nil || r.Type != DTypeBool {
+				t.Errorf("%s with %v: got %s, %v, want a bool", src, x, r, err)
+			}
+		}
+	}
+}
+
+// countTracer counts instructions and calls cancel when it reaches at.
+type countTracer struct {
+	n      int
+	at     int
+	cancel func()
+}
+
+func (c *countTracer) OnInstruction(pc int, op Opcode, stack []*Data) {
+	c.n++
+	if c.n == c.at && c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func (c *countTracer) OnStatic(name string, value *Data, dur time.Duration) {}
+func (c *countTracer) OnCall(fn string, args []*Data, result *Data)         {}
+func (c *countTracer) OnError(err error)                                    {}
+
+// longProgram compiles a sum of n terms without folding it away.
+func longProgram(t *testing.T, n int) *Program {
+	return compileWith(t, "@x"+strings.Repeat(" + 1", n), Options{NoOptimize: true})
+}
+
+func TestInstructionLimit(t *testing.T) {
+	env := NewEnv()
+	env.Set("x", StaticValue(NewFloat(0)))
+	p := longProgram(t, 100)
+	var c countTracer
+	if _, err := p.Run(context.Background(), env, WithTracer(&c)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.WithOptions(Options{Limits: Limits{Instructions: c.n}}).Run(context.Background(), env)
+	if err != nil || !r.Equal(NewFloat(100)) {
+		t.Errorf("limit %d: got %s, %v, want 100", c.n, r, err)
+	}
+	r, err = p.WithOptions(Options{Limits: Limits{Instructions: c.n - 1}}).Run(context.Background(), env)
+	if !errors.Is(err, LimitExceeded) || !errors.Is(err, ErrInstructionLimit) {
+		t.Errorf("limit %d: got %s, %v, want %v", c.n-1, r, err, ErrInstructionLimit)
+	}
+}
+
+func TestCancelLongRun(t *testing.T) {
+	env := NewEnv()
+	env.Set("x", StaticValue(NewFloat(0)))
+	p := longProgram(t, 2000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &countTracer{at: 1000, cancel: cancel}
+	r, err := p.Run(ctx, env, WithTracer(c))
+	if !errors.Is(err, Canceled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %s, %v, want a Canceled error", r, err)
+	}
+	if c.n > c.at+cancelEvery {
+		t.Errorf("ran %d instructions after the context was canceled", c.n-c.at)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if r, err := p.Run(ctx, env); !errors.Is(err, Canceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("past deadline: got %s, %v, want a Canceled error", r, err)
+	}
+}
+
+func TestCancelBlockedStatic(t *testing.T) {
+	env := NewEnv()
+	env.Set("slow", func(ctx context.Context) (*Data, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r, err := compile(t, `@slow + 1`).Run(ctx, env)
+	if !errors.Is(err, Canceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %s, %v, want a Canceled error", r, err)
+	}
+}